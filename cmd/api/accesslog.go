@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// responseRecorder remembers the status code and size of the response written through
+// it, for the access log.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if !rr.wroteHeader {
+		rr.status = status
+		rr.wroteHeader = true
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.wroteHeader = true
+	n, err := rr.ResponseWriter.Write(b)
+	rr.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController get at the underlying ResponseWriter.
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+// accessLogEntry is filled in as the request goes down the middleware chain. Only the
+// user ID, and who is impersonating them, need to be, as they're added to the request's
+// context further down than the access log can see; see contextSetUser() and
+// contextSetToken().
+type accessLogEntry struct {
+	userID         int64
+	impersonatorID int64
+}
+
+// accessLog logs a line for every request, through app.accessLogger. Only the route
+// pattern is logged, never the URL, since the URL can have tokens in it, and neither
+// are any headers. With -access-log-sample below 1, only that fraction of successful
+// requests is logged; errors always are.
+func (app *application) accessLog(router *httprouter.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.accessLog.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		entry := &accessLogEntry{}
+		rr := newResponseRecorder(w)
+
+		next.ServeHTTP(rr, app.contextSetAccessLogEntry(r, entry))
+
+		if rr.status < 400 && rand.Float64() >= app.config.accessLog.sample {
+			return
+		}
+
+		properties := map[string]string{
+			"method":      r.Method,
+			"route":       routePattern(router, r),
+			"status":      strconv.Itoa(rr.status),
+			"bytes":       strconv.Itoa(rr.bytes),
+			"duration_ms": strconv.FormatFloat(float64(time.Since(start).Microseconds())/1000, 'f', 3, 64),
+		}
+
+		if entry.userID != 0 {
+			properties["user_id"] = strconv.FormatInt(entry.userID, 10)
+		}
+
+		if entry.impersonatorID != 0 {
+			properties["impersonator_id"] = strconv.FormatInt(entry.impersonatorID, 10)
+		}
+
+		if ip := app.clientIP(r); ip != nil {
+			properties["ip"] = ip.String()
+		}
+
+		logger := app.accessLogger
+		if id := app.contextGetRequestID(r); id != "" {
+			logger = logger.With(map[string]string{"request_id": id})
+		}
+
+		logger.PrintInfo("request", properties)
+	})
+}
+
+// routePattern returns the pattern of the route the request matches, such as
+// /v1/movies/:id, so that requests for different movies are logged and counted under
+// the same route. It's worked out from the path and the parameters the router pulls
+// out of it, since httprouter doesn't say which pattern matched. Requests which don't
+// match a route give "".
+func routePattern(router *httprouter.Router, r *http.Request) string {
+	handle, params, _ := router.Lookup(r.Method, r.URL.Path)
+	if handle == nil {
+		return ""
+	}
+
+	segments := strings.Split(r.URL.Path, "/")
+
+	i := 0
+	for _, param := range params {
+		for ; i < len(segments); i++ {
+			if segments[i] == param.Value {
+				segments[i] = ":" + param.Key
+				i++
+				break
+			}
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
+)
+
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	keys, err := app.models.APIKeys.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"api_keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	// API keys can't be minted with an API key or an OAuth access token, otherwise a
+	// leaked credential could be used to outlive its own expiry or shed its restrictions.
+	if app.isDelegatedCredential(r) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Name       string    `json:"name"`
+		Scopes     []string  `json:"scopes"`
+		AllowedIPs []string  `json:"allowed_ips"`
+		Expiry     time.Time `json:"expiry"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	if input.AllowedIPs == nil {
+		input.AllowedIPs = []string{}
+	}
+
+	key := &data.APIKey{
+		Name:       input.Name,
+		Scopes:     input.Scopes,
+		AllowedIPs: input.AllowedIPs,
+		Expiry:     input.Expiry,
+	}
+
+	v := validator.New()
+	if data.ValidateAPIKey(v, key); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Keys are scoped to a subset of the user's own permissions. This is checked again
+	// on every request, so revoking a permission from the user also revokes it from
+	// their keys.
+	permissions, err := app.permissionsForRequest(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, scope := range key.Scopes {
+		if !permissions.Include(scope) {
+			v.AddError("scopes", fmt.Sprintf("must only contain permissions you have (%q is not one of them)", scope))
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	key, err = app.models.APIKeys.New(user.ID, key.Name, key.Expiry, key.Scopes, key.AllowedIPs)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, data.AuditTokenCreated, user.ID, map[string]string{
+		"scope":      "api_key",
+		"api_key_id": strconv.FormatInt(key.ID, 10),
+	})
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/users/me/api-keys/%d", key.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"api_key": key}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readNamedIDParam(r, "key_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.APIKeys.DeleteForUser(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, data.AuditTokenRevoked, user.ID, map[string]string{
+		"scope":      "api_key",
+		"api_key_id": strconv.FormatInt(id, 10),
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "api key successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
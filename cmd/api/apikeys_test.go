@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIKeys(t *testing.T) {
+	app := newTestApplication(t, newTestDB(t))
+	h := app.routes()
+
+	user := newTestUser(t, app, "alice@example.com", "movies:read", "movies:write")
+	token := newTestToken(t, app, user)
+
+	expiry := time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339)
+
+	create := func(credential, body string) (*http.Response, map[string]any) {
+		r := withBearer(httptest.NewRequest(http.MethodPost, "/v1/users/me/api-keys", strings.NewReader(body)), credential)
+		return do(t, h, r)
+	}
+
+	res, body := create(token, `{"name": "nightly import", "scopes": ["movies:read"], "expiry": "`+expiry+`"}`)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("creating a key: got status %d (%v)", res.StatusCode, body)
+	}
+
+	apiKey, _ := body["api_key"].(map[string]any)
+	key, _ := apiKey["key"].(string)
+	keyID, _ := apiKey["id"].(float64)
+	if !strings.HasPrefix(key, "glk_") {
+		t.Fatalf("got key %q; want one starting glk_", key)
+	}
+
+	res, body = create(token, `{"name": "office only", "scopes": ["movies:read"], "allowed_ips": ["10.0.0.0/8"], "expiry": "`+expiry+`"}`)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("creating an IP restricted key: got status %d (%v)", res.StatusCode, body)
+	}
+
+	apiKey, _ = body["api_key"].(map[string]any)
+	restricted, _ := apiKey["key"].(string)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		credential string
+		body       string
+		wantStatus int
+	}{
+		{"read within the key's scopes", http.MethodGet, "/v1/movies", key, "", http.StatusOK},
+		{"write outside the key's scopes", http.MethodPost, "/v1/movies", key, `{}`, http.StatusForbidden},
+		{"creating a key with a key", http.MethodPost, "/v1/users/me/api-keys", key, `{"name": "copy", "scopes": ["movies:read"], "expiry": "` + expiry + `"}`, http.StatusForbidden},
+		{"key used from outside its allowed IPs", http.MethodGet, "/v1/movies", restricted, "", http.StatusUnauthorized},
+		{"made-up key", http.MethodGet, "/v1/movies", "glk_" + strings.Repeat("A", 32), "", http.StatusUnauthorized},
+		{"scope the user doesn't have", http.MethodPost, "/v1/users/me/api-keys", token, `{"name": "admin", "scopes": ["users:admin"], "expiry": "` + expiry + `"}`, http.StatusUnprocessableEntity},
+		{"expiry too far away", http.MethodPost, "/v1/users/me/api-keys", token, `{"name": "forever", "scopes": ["movies:read"], "expiry": "2999-01-01T00:00:00Z"}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := withBearer(httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)), tt.credential)
+			res, body := do(t, h, r)
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d; want %d (%v)", res.StatusCode, tt.wantStatus, body)
+			}
+		})
+	}
+
+	t.Run("deleted key", func(t *testing.T) {
+		r := withBearer(httptest.NewRequest(http.MethodDelete, "/v1/users/me/api-keys/"+strconv.FormatInt(int64(keyID), 10), nil), token)
+		res, body := do(t, h, r)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("deleting: got status %d (%v)", res.StatusCode, body)
+		}
+
+		res, _ = do(t, h, withBearer(httptest.NewRequest(http.MethodGet, "/v1/movies", nil), key))
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("got status %d; want %d", res.StatusCode, http.StatusUnauthorized)
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+)
+
+// Admin changes to roles and to whether users are activated are audited.
+func TestAuditAdminChanges(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db)
+	h := app.routes()
+
+	admin := newTestUser(t, app, "admin@example.com", "permissions:admin", "users:admin")
+	adminToken := newTestToken(t, app, admin)
+
+	user := newTestUser(t, app, "alice@example.com", "movies:read")
+	userPath := "/v1/users/" + strconv.FormatInt(user.ID, 10)
+
+	res, body := do(t, h, withBearer(httptest.NewRequest(http.MethodPost, "/v1/roles", strings.NewReader(`{"name": "critic", "permissions": ["movies:read"]}`)), adminToken))
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("creating a role: got status %d (%v)", res.StatusCode, body)
+	}
+	rolePath := res.Header.Get("Location")
+
+	steps := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodPatch, rolePath, `{"description": "Writes reviews"}`},
+		{http.MethodDelete, rolePath, ``},
+		{http.MethodPatch, userPath, `{"activated": false}`},
+		{http.MethodPatch, userPath, `{"activated": true}`},
+	}
+
+	for _, step := range steps {
+		res, body := do(t, h, withBearer(httptest.NewRequest(step.method, step.path, strings.NewReader(step.body)), adminToken))
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s %s: got status %d (%v)", step.method, step.path, res.StatusCode, body)
+		}
+	}
+
+	rows, err := db.Query("SELECT action FROM audit_events WHERE actor_id = $1 ORDER BY id", admin.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var actions []string
+
+	for rows.Next() {
+		var action string
+
+		err := rows.Scan(&action)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actions = append(actions, action)
+	}
+
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deactivating logs the user out everywhere, which is audited before the
+	// deactivation itself.
+	want := []string{
+		data.AuditRoleCreated,
+		data.AuditRoleUpdated,
+		data.AuditRoleDeleted,
+		data.AuditTokenRevoked,
+		data.AuditUserDeactivated,
+		data.AuditUserReactivated,
+	}
+
+	if !slices.Equal(actions, want) {
+		t.Errorf("got actions %v; want %v", actions, want)
+	}
+}
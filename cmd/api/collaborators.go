@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
+)
+
+// requireMovieEditor checks that the user may change the movie: they created it, it has
+// been shared with them, or they have the movies:admin permission. Otherwise it sends a
+// 403 Forbidden response and returns false.
+func (app *application) requireMovieEditor(w http.ResponseWriter, r *http.Request, movie *data.Movie) bool {
+	return app.requireMovieAccess(w, r, movie, true)
+}
+
+// requireMovieOwner is like requireMovieEditor, except that collaborators don't count.
+// Only the owner and movie admins can decide who else gets to edit a movie.
+func (app *application) requireMovieOwner(w http.ResponseWriter, r *http.Request, movie *data.Movie) bool {
+	return app.requireMovieAccess(w, r, movie, false)
+}
+
+func (app *application) requireMovieAccess(w http.ResponseWriter, r *http.Request, movie *data.Movie, collaborators bool) bool {
+	user := app.contextGetUser(r)
+
+	permissions, err := app.permissionsForRequest(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if permissions.Include("movies:admin") {
+		return true
+	}
+
+	if movie.CreatedBy != nil && *movie.CreatedBy == user.ID {
+		return true
+	}
+
+	if collaborators {
+		ok, err := app.models.Movies.IsCollaborator(movie.ID, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
+		if ok {
+			return true
+		}
+	}
+
+	app.notPermittedResponse(w, r)
+	return false
+}
+
+func (app *application) listMovieCollaboratorsHandler(w http.ResponseWriter, r *http.Request) {
+	movie, err := app.movieForIDParam(w, r)
+	if err != nil {
+		return
+	}
+
+	if !app.requireMovieEditor(w, r, movie) {
+		return
+	}
+
+	app.writeMovieCollaborators(w, r, movie)
+}
+
+// The addMovieCollaboratorHandler shares a movie with another user, found by email
+// address, so that they can edit it too.
+func (app *application) addMovieCollaboratorHandler(w http.ResponseWriter, r *http.Request) {
+	movie, err := app.movieForIDParam(w, r)
+	if err != nil {
+		return
+	}
+
+	if !app.requireMovieOwner(w, r, movie) {
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", "no matching user found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if movie.CreatedBy != nil && *movie.CreatedBy == user.ID {
+		v.AddError("email", "the movie's owner can't also be a collaborator")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Movies.AddCollaborator(movie.ID, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.requestLogger(r).PrintInfo("movie shared", map[string]string{
+		"movie_id": strconv.FormatInt(movie.ID, 10),
+		"actor_id": strconv.FormatInt(app.contextGetUser(r).ID, 10),
+		"user_id":  strconv.FormatInt(user.ID, 10),
+	})
+
+	app.writeMovieCollaborators(w, r, movie)
+}
+
+// The removeMovieCollaboratorHandler stops sharing a movie with a user. Besides the
+// owner and movie admins, collaborators can remove themselves.
+func (app *application) removeMovieCollaboratorHandler(w http.ResponseWriter, r *http.Request) {
+	movie, err := app.movieForIDParam(w, r)
+	if err != nil {
+		return
+	}
+
+	userID, err := app.readNamedIDParam(r, "user_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if userID != app.contextGetUser(r).ID && !app.requireMovieOwner(w, r, movie) {
+		return
+	}
+
+	err = app.models.Movies.RemoveCollaborator(movie.ID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "collaborator successfully removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) writeMovieCollaborators(w http.ResponseWriter, r *http.Request, movie *data.Movie) {
+	users, err := app.models.Users.GetAllCollaborators(movie.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"collaborators": users}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// movieForIDParam fetches the movie named by the :id parameter, sending the error
+// response itself like userForIDParam.
+func (app *application) movieForIDParam(w http.ResponseWriter, r *http.Request) (*data.Movie, error) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, err
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, err
+	}
+
+	return movie, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMovieCollaborators(t *testing.T) {
+	app := newTestApplication(t, newTestDB(t))
+	h := app.routes()
+
+	owner := newTestUser(t, app, "alice@example.com", "movies:read", "movies:write")
+	bob := newTestUser(t, app, "bob@example.com", "movies:read", "movies:write")
+	carol := newTestUser(t, app, "carol@example.com", "movies:read", "movies:write")
+	admin := newTestUser(t, app, "admin@example.com", "movies:read", "movies:write", "movies:admin")
+
+	tokens := map[string]string{
+		"owner": newTestToken(t, app, owner),
+		"bob":   newTestToken(t, app, bob),
+		"carol": newTestToken(t, app, carol),
+		"admin": newTestToken(t, app, admin),
+	}
+
+	send := func(as, method, path, body string) (*http.Response, map[string]any) {
+		return do(t, h, withBearer(httptest.NewRequest(method, path, strings.NewReader(body)), tokens[as]))
+	}
+
+	res, body := send("owner", http.MethodPost, "/v1/movies", `{"title": "Moana", "year": 2016, "runtime": 107, "genres": ["animation"]}`)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("creating a movie: got status %d (%v)", res.StatusCode, body)
+	}
+	movie := res.Header.Get("Location")
+
+	bobPath := movie + "/collaborators/" + strconv.FormatInt(bob.ID, 10)
+
+	// Each step depends on the ones before it.
+	steps := []struct {
+		name       string
+		as         string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"someone else editing", "bob", http.MethodPatch, movie, `{"title": "Moana 2"}`, http.StatusForbidden},
+		{"someone else sharing", "bob", http.MethodPost, movie + "/collaborators", `{"email": "bob@example.com"}`, http.StatusForbidden},
+		{"owner sharing", "owner", http.MethodPost, movie + "/collaborators", `{"email": "bob@example.com"}`, http.StatusOK},
+		{"owner sharing with themselves", "owner", http.MethodPost, movie + "/collaborators", `{"email": "alice@example.com"}`, http.StatusUnprocessableEntity},
+		{"collaborator editing", "bob", http.MethodPatch, movie, `{"title": "Moana 2"}`, http.StatusOK},
+		{"collaborator listing collaborators", "bob", http.MethodGet, movie + "/collaborators", ``, http.StatusOK},
+		{"collaborator sharing", "bob", http.MethodPost, movie + "/collaborators", `{"email": "carol@example.com"}`, http.StatusForbidden},
+		{"someone else removing a collaborator", "carol", http.MethodDelete, bobPath, ``, http.StatusForbidden},
+		{"collaborator removing themselves", "bob", http.MethodDelete, bobPath, ``, http.StatusOK},
+		{"former collaborator editing", "bob", http.MethodPatch, movie, `{"title": "Moana 3"}`, http.StatusForbidden},
+		{"movie admin editing", "admin", http.MethodPatch, movie, `{"title": "Moana 3"}`, http.StatusOK},
+		{"someone else deleting", "carol", http.MethodDelete, movie, ``, http.StatusForbidden},
+		{"owner deleting", "owner", http.MethodDelete, movie, ``, http.StatusOK},
+	}
+
+	for _, step := range steps {
+		res, body := send(step.as, step.method, step.path, step.body)
+		if res.StatusCode != step.wantStatus {
+			t.Fatalf("%s: got status %d; want %d (%v)", step.name, res.StatusCode, step.wantStatus, body)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/jwt"
 )
 
 // Define a custom contextKey type, with the underlying type string.
@@ -15,10 +16,33 @@ type contextKey string
 // in the request context.
 const userContextKey = contextKey("user")
 
+// The claimsContextKey is used to store the verified claims of a stateless (JWT)
+// authentication token, when the request was authenticated with one.
+const claimsContextKey = contextKey("claims")
+
+// The apiKeyContextKey is used to store the API key a request was authenticated with.
+const apiKeyContextKey = contextKey("api_key")
+
+// The tokenContextKey is used to store the opaque bearer token a request was
+// authenticated with, so that OAuth access tokens can be told apart.
+const tokenContextKey = contextKey("token")
+
+// The requestIDContextKey is used to store the ID the requestID() middleware gives
+// each request.
+const requestIDContextKey = contextKey("request_id")
+
+// The accessLogEntryContextKey is used to store the entry the accessLog() middleware
+// is filling in for the request.
+const accessLogEntryContextKey = contextKey("access_log_entry")
+
 // The contextSetUser() method returns a new copy of the request with the provided
 // User struct added to the context. Note that we use our userContextKey constant as the
 // key.
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	if entry, ok := r.Context().Value(accessLogEntryContextKey).(*accessLogEntry); ok && !user.IsAnonymous() {
+		entry.userID = user.ID
+	}
+
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
@@ -34,3 +58,66 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// The contextSetClaims() method returns a new copy of the request with the verified JWT
+// claims added to the context.
+func (app *application) contextSetClaims(r *http.Request, claims *jwt.Claims) *http.Request {
+	ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+	return r.WithContext(ctx)
+}
+
+// The contextGetClaims() method retrieves the JWT claims from the request context.
+// Unlike the user, the claims are only present for requests authenticated with a JWT,
+// so the second return value reports whether they were found.
+func (app *application) contextGetClaims(r *http.Request) (*jwt.Claims, bool) {
+	claims, ok := r.Context().Value(claimsContextKey).(*jwt.Claims)
+	return claims, ok
+}
+
+// The contextSetAPIKey() method returns a new copy of the request with the API key used
+// to authenticate it added to the context.
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// The contextGetAPIKey() method retrieves the API key from the request context, if the
+// request was authenticated with one.
+func (app *application) contextGetAPIKey(r *http.Request) (*data.APIKey, bool) {
+	key, ok := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key, ok
+}
+
+// The contextSetToken() method returns a new copy of the request with the bearer token
+// used to authenticate it added to the context.
+func (app *application) contextSetToken(r *http.Request, token *data.Token) *http.Request {
+	if entry, ok := r.Context().Value(accessLogEntryContextKey).(*accessLogEntry); ok && token.ImpersonatorID != nil {
+		entry.impersonatorID = *token.ImpersonatorID
+	}
+
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// The contextGetToken() method retrieves the bearer token from the request context, if
+// the request was authenticated with an opaque token.
+func (app *application) contextGetToken(r *http.Request) (*data.Token, bool) {
+	token, ok := r.Context().Value(tokenContextKey).(*data.Token)
+	return token, ok
+}
+
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// The contextGetRequestID() method returns the request's ID, or "" if it hasn't got one.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+func (app *application) contextSetAccessLogEntry(r *http.Request, entry *accessLogEntry) *http.Request {
+	ctx := context.WithValue(r.Context(), accessLogEntryContextKey, entry)
+	return r.WithContext(ctx)
+}
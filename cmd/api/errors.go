@@ -2,12 +2,17 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
 )
 
 func (app *application) logError(r *http.Request, err error) {
 	// app.logger.Print(err)
-	app.logger.PrintError(err, map[string]string{
+	app.requestLogger(r).PrintError(err, map[string]string{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
 	})
@@ -20,6 +25,12 @@ func (app *application) logError(r *http.Request, err error) {
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
 	env := envelope{"error": message}
 
+	// Server errors are logged, and the request ID lets users and support find the
+	// log entry for theirs.
+	if id := app.contextGetRequestID(r); id != "" && status >= 500 {
+		env["request_id"] = id
+	}
+
 	// Write the response using the writeJSON() helper. If this happens to return an
 	// error then log it, and fall back to sending the client an empty response with a
 	// 500 Internal Server Error status code.
@@ -72,6 +83,15 @@ func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
 
+// loginLockedResponse is sent instead of trying the credentials when there have been
+// too many failed logins for the account or from the client IP.
+func (app *application) loginLockedResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
@@ -95,6 +115,33 @@ func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 }
 
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	app.audit(r, data.AuditAccessDenied, 0, map[string]string{
+		"method": r.Method,
+		"path":   r.URL.Path,
+	})
+
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) registrationClosedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "new accounts can't be registered with this email address without an invitation"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// The oauthErrorResponse() method sends an error in the format required by RFC 6749
+// section 5.2, which OAuth client libraries expect from the token endpoint.
+func (app *application) oauthErrorResponse(w http.ResponseWriter, r *http.Request, status int, code, description string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+
+	err := app.writeJSON(w, status, envelope{"error": code, "error_description": description}, headers)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
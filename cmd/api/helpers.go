@@ -5,11 +5,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/jsonlog"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/mailer"
 	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
 	"github.com/julienschmidt/httprouter"
 )
@@ -39,6 +43,16 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// readNamedIDParam is readIDParam for routes with more than one ID in them, such as
+// /v1/users/:id/api-keys/:key_id.
+func (app *application) readNamedIDParam(r *http.Request, name string) (int64, error) {
+	id, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName(name), 10, 64)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid %s parameter", name)
+	}
+	return id, nil
+}
+
 // writeJSON helper method
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
 	// Go supports encoding many other native types, check README file
@@ -163,24 +177,72 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 	return nil
 }
 
+// The clientIP() helper returns the IP address of the client which made the request,
+// or nil if it can't be determined.
+func (app *application) clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+
+	// Each proxy appends the address it got the request from, so the header is read
+	// from the right, and the first address which isn't a trusted proxy is the client.
+	// Anything to the left of that could have been made up by the client.
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+
+	for i := len(hops) - 1; i >= 0 && app.trustedProxy(ip); i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+	}
+
+	return ip
+}
+
+func (app *application) trustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range app.config.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // json.SyntaxError
 // io.ErrUnexpectedEOF
 // json.UnmarshalTypeError
 // json.InvalidUnmarshalError
 // io.EOF
 
-func (app *application) background(fn func()) {
+// The background() helper runs fn in a goroutine, on behalf of the request r. Inside
+// fn, use app.requestLogger(r) and app.requestMailer(r) so that the log entries and
+// emails it leads to carry the request's ID too.
+func (app *application) background(r *http.Request, fn func()) {
 
 	app.wg.Add(1)
+	app.metricSet.backgroundRunning.Inc()
 	// Launch a background goroutine.
 	go func() {
 		defer app.wg.Done()
+		defer app.metricSet.backgroundRunning.Dec()
 
 		// Recover any panic.
 		defer func() {
 			if err := recover(); err != nil {
-				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+				app.metricSet.backgroundTasks.Inc("panicked")
+				app.requestLogger(r).PrintError(fmt.Errorf("%s", err), nil)
+				return
 			}
+			app.metricSet.backgroundTasks.Inc("completed")
 		}()
 
 		// Execute the arbitrary function that we passed as the parameter.
@@ -190,6 +252,27 @@ func (app *application) background(fn func()) {
 	// for any background goroutines before terminating the application
 }
 
+// requestLogger returns app.logger with the request's ID added to every entry.
+func (app *application) requestLogger(r *http.Request) *jsonlog.Logger {
+	id := app.contextGetRequestID(r)
+	if id == "" {
+		return app.logger
+	}
+
+	return app.logger.With(map[string]string{"request_id": id})
+}
+
+// requestMailer returns app.mailer with the request's ID added to every email as an
+// X-Request-ID header, so that bounces and mail provider logs can be traced back.
+func (app *application) requestMailer(r *http.Request) mailer.Mailer {
+	id := app.contextGetRequestID(r)
+	if id == "" {
+		return app.mailer
+	}
+
+	return app.mailer.WithHeader("X-Request-ID", id)
+}
+
 // Filtering, Sorting and Pagination: Reading Query String Parameters
 
 // The readString() helper returns a string value from the query string, or the provided
@@ -248,3 +331,41 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	// Otherwise, return the converted integer value.
 	return i
 }
+
+// The readBool() helper reads a boolean value from the query string, accepting the same
+// values as strconv.ParseBool(). Like readInt() it records an error message in the
+// Validator instance if the value can't be parsed.
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
+// The readTime() helper reads an RFC 3339 timestamp from the query string, returning the
+// zero time if there isn't one. Like readInt() it records an error message in the
+// Validator instance if the value can't be parsed.
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp, like 2006-01-02T15:04:05Z")
+		return time.Time{}
+	}
+
+	return t
+}
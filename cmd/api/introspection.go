@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/jwt"
+)
+
+// authenticateServiceClient authenticates an internal service calling the
+// introspection or revocation endpoint. Services are confidential OAuth clients which
+// were registered with the given permission among their scopes, and whose owner still
+// holds that permission.
+func (app *application) authenticateServiceClient(w http.ResponseWriter, r *http.Request, permission string) (*data.OAuthClient, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+
+	err := r.ParseForm()
+	if err != nil {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "the request body could not be parsed")
+		return nil, false
+	}
+
+	client, authenticated, err := app.authenticateOAuthClient(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.oauthErrorResponse(w, r, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if !authenticated {
+		app.oauthErrorResponse(w, r, http.StatusUnauthorized, "invalid_client", "a service credential is required")
+		return nil, false
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(client.UserID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	if !permissions.Intersect(client.Scopes).Include(permission) {
+		app.oauthErrorResponse(w, r, http.StatusForbidden, "unauthorized_client", "the client is not allowed to use this endpoint")
+		return nil, false
+	}
+
+	return client, true
+}
+
+// The introspectTokenHandler implements RFC 7662 token introspection, so that other
+// services can accept our bearer tokens without access to our database. Anything that
+// isn't a currently valid token is reported as {"active": false} and nothing more.
+func (app *application) introspectTokenHandler(w http.ResponseWriter, r *http.Request) {
+	_, ok := app.authenticateServiceClient(w, r, "tokens:introspect")
+	if !ok {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+
+	inactive := envelope{"active": false}
+
+	plaintext := r.PostForm.Get("token")
+	if plaintext == "" {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "token must be provided")
+		return
+	}
+
+	var userID int64
+	var expiry time.Time
+	var scopes []string
+	var clientID *int64
+
+	if app.config.jwt.enabled && jwt.LooksLikeJWT(plaintext) {
+		_, claims, err := app.userForJWT(plaintext)
+		if err != nil {
+			err = app.writeJSON(w, http.StatusOK, inactive, headers)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		userID, _ = strconv.ParseInt(claims.Subject, 10, 64)
+		expiry = time.Unix(claims.Expiry, 0)
+		scopes = claims.Permissions
+	} else {
+		tokenHash := sha256.Sum256([]byte(plaintext))
+
+		token, err := app.models.Tokens.GetForHash(tokenHash[:])
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				err = app.writeJSON(w, http.StatusOK, inactive, headers)
+				if err != nil {
+					app.serverErrorResponse(w, r, err)
+				}
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		permissions, err := app.models.Permissions.GetAllForUser(token.UserID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// As in requirePermission(), OAuth access tokens only carry the part of the
+		// user's permissions which was granted to the client.
+		if token.ClientID != nil {
+			permissions = permissions.Intersect(token.Permissions)
+		}
+
+		userID = token.UserID
+		expiry = token.Expiry
+		scopes = permissions
+		clientID = token.ClientID
+	}
+
+	env := envelope{
+		"active":     true,
+		"token_type": "Bearer",
+		"sub":        strconv.FormatInt(userID, 10),
+		"user_id":    userID,
+		"scope":      strings.Join(scopes, " "),
+		"exp":        expiry.Unix(),
+	}
+
+	if clientID != nil {
+		env["client_id"] = *clientID
+	}
+
+	err := app.writeJSON(w, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The revokeTokenHandler implements RFC 7009 token revocation. As the RFC requires, it
+// responds with 200 OK whether or not the token existed, so callers can't use it to
+// probe for valid tokens.
+func (app *application) revokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	client, ok := app.authenticateServiceClient(w, r, "tokens:revoke")
+	if !ok {
+		return
+	}
+
+	plaintext := r.PostForm.Get("token")
+	if plaintext == "" {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "token must be provided")
+		return
+	}
+
+	if app.config.jwt.enabled && jwt.LooksLikeJWT(plaintext) {
+		// JWTs can't be deleted, so a valid one is added to the deny-list until it
+		// would have expired anyway.
+		if _, claims, err := app.userForJWT(plaintext); err == nil {
+			revocation := &data.Revocation{
+				JTI:    &claims.ID,
+				Expiry: time.Unix(claims.Expiry, 0),
+			}
+
+			err = app.models.Revocations.Insert(revocation)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			app.denylist.add(revocation)
+		}
+	} else {
+		tokenHash := sha256.Sum256([]byte(plaintext))
+
+		err := app.models.Tokens.DeleteForHash(tokenHash[:])
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// The token itself is never recorded, and whether it existed isn't either.
+	app.audit(r, data.AuditTokenRevoked, 0, map[string]string{
+		"oauth_client_id": strconv.FormatInt(client.ID, 10),
+	})
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+
+	err := app.writeJSON(w, http.StatusOK, envelope{}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
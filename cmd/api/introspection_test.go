@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestIntrospectAndRevoke(t *testing.T) {
+	app := newTestApplication(t, newTestDB(t))
+	h := app.routes()
+
+	service := newTestUser(t, app, "service@example.com", "tokens:introspect", "tokens:revoke")
+	client := newTestClient(t, app, service, "tokens:introspect", "tokens:revoke")
+	unscoped := newTestClient(t, app, service, "movies:read")
+
+	user := newTestUser(t, app, "alice@example.com", "movies:read")
+
+	call := func(path, clientID, secret, token string) (int, map[string]any) {
+		r := newFormRequest(path, url.Values{"token": {token}})
+		r.SetBasicAuth(clientID, secret)
+
+		res, body := do(t, h, r)
+		return res.StatusCode, body
+	}
+
+	t.Run("client authentication", func(t *testing.T) {
+		token := newTestToken(t, app, user)
+
+		tests := []struct {
+			name       string
+			path       string
+			clientID   string
+			secret     string
+			wantStatus int
+			wantError  string
+		}{
+			{"introspect with a wrong secret", "/v1/oauth/introspect", client.ClientID, "wrong", http.StatusUnauthorized, "invalid_client"},
+			{"revoke with a wrong secret", "/v1/oauth/revoke", client.ClientID, "wrong", http.StatusUnauthorized, "invalid_client"},
+			{"introspect with an unknown client", "/v1/oauth/introspect", "glc_unknown", "secret", http.StatusUnauthorized, "invalid_client"},
+			{"introspect without the scope", "/v1/oauth/introspect", unscoped.ClientID, unscoped.Secret, http.StatusForbidden, "unauthorized_client"},
+			{"revoke without the scope", "/v1/oauth/revoke", unscoped.ClientID, unscoped.Secret, http.StatusForbidden, "unauthorized_client"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				status, body := call(tt.path, tt.clientID, tt.secret, token)
+				if status != tt.wantStatus || body["error"] != tt.wantError {
+					t.Errorf("got %d %v; want %d %q", status, body["error"], tt.wantStatus, tt.wantError)
+				}
+			})
+		}
+	})
+
+	t.Run("revoked tokens are inactive", func(t *testing.T) {
+		token := newTestToken(t, app, user)
+
+		status, body := call("/v1/oauth/introspect", client.ClientID, client.Secret, token)
+		if status != http.StatusOK || body["active"] != true || body["user_id"] != float64(user.ID) {
+			t.Fatalf("before revoking: got %d %v", status, body)
+		}
+
+		status, _ = call("/v1/oauth/revoke", client.ClientID, client.Secret, token)
+		if status != http.StatusOK {
+			t.Fatalf("revoking: got status %d", status)
+		}
+
+		status, body = call("/v1/oauth/introspect", client.ClientID, client.Secret, token)
+		if status != http.StatusOK || body["active"] != false || len(body) != 1 {
+			t.Errorf("after revoking: got %d %v", status, body)
+		}
+
+		// Revoking a token which doesn't exist looks the same as revoking one which does.
+		status, _ = call("/v1/oauth/revoke", client.ClientID, client.Secret, token)
+		if status != http.StatusOK {
+			t.Errorf("revoking again: got status %d", status)
+		}
+	})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
+)
+
+// Registration modes, set with -registration-mode.
+const (
+	registrationOpen       = "open"
+	registrationDomains    = "domains"
+	registrationInvitation = "invitation"
+)
+
+const invitationTTL = 7 * 24 * time.Hour
+
+var errRegistrationClosed = errors.New("registration closed to email address")
+
+// checkRegistration reports whether someone can register with the email address
+// without an invitation, adding an error to v if they can't.
+func (app *application) checkRegistration(v *validator.Validator, email string) {
+	switch app.config.registration.mode {
+	case registrationInvitation:
+		v.AddError("invitation_token", "must be provided, as registration is by invitation only")
+	case registrationDomains:
+		if !app.registrationDomainAllowed(email) {
+			v.AddError("email", "must be an address at one of the domains which can register")
+		}
+	}
+}
+
+func (app *application) registrationDomainAllowed(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+
+	return slices.Contains(app.config.registration.domains, strings.ToLower(email[at+1:]))
+}
+
+// invitationForRegistration looks up the invitation with the token the user registered
+// with. The email address can be left out, in which case the invited one is used, but
+// if it's given it has to be the invited one.
+func (app *application) invitationForRegistration(v *validator.Validator, tokenPlaintext string, email *string) (*data.Invitation, error) {
+	invitation, err := app.models.Invitations.GetForToken(tokenPlaintext)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			v.AddError("invitation_token", "invalid or expired invitation token")
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if *email == "" {
+		*email = invitation.Email
+	} else if !strings.EqualFold(*email, invitation.Email) {
+		v.AddError("email", "must be the address the invitation was sent to")
+	}
+
+	return invitation, nil
+}
+
+// The createInvitationHandler invites someone to register, and emails them the token
+// to do it with. The invitation can come with permissions, but only ones the admin
+// sending it has.
+func (app *application) createInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email       string   `json:"email"`
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	actor := app.contextGetUser(r)
+
+	invitation := &data.Invitation{
+		Email:       input.Email,
+		Permissions: input.Permissions,
+		CreatedBy:   actor.ID,
+	}
+
+	if invitation.Permissions == nil {
+		invitation.Permissions = []string{}
+	}
+
+	v := validator.New()
+
+	if data.ValidateInvitation(v, invitation); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	all, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.permissionsForRequest(r, actor)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	validatePermissionCodes(v, all, invitation.Permissions)
+
+	for _, code := range invitation.Permissions {
+		if !permissions.Include(code) {
+			v.AddError("permissions", fmt.Sprintf("must only contain permissions you have (%q is not one of them)", code))
+		}
+	}
+
+	_, err = app.models.Users.GetByEmail(invitation.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Invitations.New(invitation, invitationTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(r, func() {
+		data := map[string]any{
+			"email":           invitation.Email,
+			"invitationToken": invitation.Plaintext,
+			"days":            int(invitationTTL.Hours() / 24),
+		}
+
+		err := app.requestMailer(r).Send(invitation.Email, "invitation.tmpl", data)
+		if err != nil {
+			app.requestLogger(r).PrintError(err, nil)
+		}
+	})
+
+	app.requestLogger(r).PrintInfo("user invited", map[string]string{
+		"actor_id":      strconv.FormatInt(actor.ID, 10),
+		"invitation_id": strconv.FormatInt(invitation.ID, 10),
+		"permissions":   strings.Join(invitation.Permissions, ","),
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"invitation": invitation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
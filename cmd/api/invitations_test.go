@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+)
+
+func TestRegisterWithInvitation(t *testing.T) {
+	app := newTestApplication(t, newTestDB(t))
+	app.config.registration.mode = registrationInvitation
+	h := app.routes()
+
+	admin := newTestUser(t, app, "admin@example.com", "users:admin", "movies:write")
+
+	invitation := &data.Invitation{
+		Email:       "carol@example.com",
+		Permissions: []string{"movies:write"},
+		CreatedBy:   admin.ID,
+	}
+
+	err := app.models.Invitations.New(invitation, invitationTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	register := func(body string) (*http.Response, map[string]any) {
+		return do(t, h, httptest.NewRequest(http.MethodPost, "/v1/users", strings.NewReader(body)))
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantError  string
+	}{
+		{"no invitation", `{"name": "Dave", "email": "dave@example.com", "password": "correct horse battery staple"}`, http.StatusUnprocessableEntity, "invitation_token"},
+		{"someone else's invitation", `{"name": "Dave", "email": "dave@example.com", "password": "correct horse battery staple", "invitation_token": "` + invitation.Plaintext + `"}`, http.StatusUnprocessableEntity, "email"},
+		{"invited", `{"name": "Carol", "password": "correct horse battery staple", "invitation_token": "` + invitation.Plaintext + `"}`, http.StatusCreated, ""},
+		{"invitation used already", `{"name": "Carol", "password": "correct horse battery staple", "invitation_token": "` + invitation.Plaintext + `"}`, http.StatusUnprocessableEntity, "invitation_token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, body := register(tt.body)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d; want %d (%v)", res.StatusCode, tt.wantStatus, body)
+			}
+
+			if tt.wantError != "" {
+				errs, _ := body["error"].(map[string]any)
+				if _, ok := errs[tt.wantError]; !ok {
+					t.Errorf("got errors %v; want one for %q", body["error"], tt.wantError)
+				}
+			}
+		})
+	}
+
+	// The invited user is activated, with the invitation's permissions.
+	user, err := app.models.Users.GetByEmail("carol@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !user.Activated {
+		t.Error("got the invited user not activated")
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !permissions.Include("movies:write") {
+		t.Errorf("got permissions %v; want them to include movies:write", permissions)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/jwt"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
+)
+
+// The denylist is an in-memory copy of the revoked_tokens table. Keeping it in memory
+// is what lets authenticate() verify a JWT without a round trip to PostgreSQL; it is
+// reloaded periodically so revocations made by other API instances are picked up.
+type denylist struct {
+	mu     sync.RWMutex
+	tokens map[string]time.Time
+	users  map[int64]time.Time
+}
+
+func newDenylist() *denylist {
+	return &denylist{
+		tokens: make(map[string]time.Time),
+		users:  make(map[int64]time.Time),
+	}
+}
+
+// add records a single revocation. For user-wide revocations we keep the latest
+// revocation time, as that covers all of the earlier ones.
+func (d *denylist) add(revocation *data.Revocation) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if revocation.JTI != nil {
+		d.tokens[*revocation.JTI] = revocation.Expiry
+	}
+
+	if revocation.UserID != nil {
+		if revocation.RevokedAt.After(d.users[*revocation.UserID]) {
+			d.users[*revocation.UserID] = revocation.RevokedAt
+		}
+	}
+}
+
+// replace swaps the contents of the denylist for a freshly loaded set of revocations.
+func (d *denylist) replace(revocations []*data.Revocation) {
+	fresh := newDenylist()
+	for _, revocation := range revocations {
+		fresh.add(revocation)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.tokens = fresh.tokens
+	d.users = fresh.users
+}
+
+// revoked reports whether the token with the given ID, issued to userID at issuedAt,
+// has been revoked.
+func (d *denylist) revoked(jti string, userID int64, issuedAt time.Time) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if _, found := d.tokens[jti]; found {
+		return true
+	}
+
+	revokedAt, found := d.users[userID]
+	return found && !issuedAt.After(revokedAt)
+}
+
+// loadDenylist reloads the denylist from the database.
+func (app *application) loadDenylist() error {
+	revocations, err := app.models.Revocations.GetAllActive()
+	if err != nil {
+		return err
+	}
+
+	app.denylist.replace(revocations)
+	return nil
+}
+
+// refreshDenylist reloads the denylist and clears out expired revocations every
+// app.config.jwt.denylistRefresh. It runs for the lifetime of the application.
+func (app *application) refreshDenylist() {
+	for {
+		time.Sleep(app.config.jwt.denylistRefresh)
+
+		err := app.models.Revocations.DeleteExpired()
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+
+		err = app.loadDenylist()
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	}
+}
+
+// newStatelessAuthenticationToken issues a signed JWT for the user. The user's
+// permissions are embedded in the token, so they are looked up once here instead of
+// on every request.
+func (app *application) newStatelessAuthenticationToken(user *data.User) (*data.Token, error) {
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &jwt.Claims{
+		Issuer:      app.config.jwt.issuer,
+		Subject:     strconv.FormatInt(user.ID, 10),
+		Activated:   user.Activated,
+		Permissions: permissions,
+	}
+
+	signed, err := app.jwtKeys.Sign(claims, app.config.jwt.ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	token := &data.Token{
+		Plaintext: signed,
+		UserID:    user.ID,
+		Expiry:    time.Unix(claims.Expiry, 0),
+		Scope:     data.ScopeAuthentication,
+	}
+
+	return token, nil
+}
+
+// userForJWT verifies a JWT and builds the request user from its claims. Any error it
+// returns means the token shouldn't be accepted, including when it has been revoked.
+func (app *application) userForJWT(token string) (*data.User, *jwt.Claims, error) {
+	claims, err := app.jwtKeys.Verify(token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if claims.Issuer != app.config.jwt.issuer {
+		return nil, nil, jwt.ErrInvalidToken
+	}
+
+	userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil || userID < 1 {
+		return nil, nil, jwt.ErrInvalidToken
+	}
+
+	if app.denylist.revoked(claims.ID, userID, time.Unix(claims.IssuedAt, 0)) {
+		return nil, nil, jwt.ErrInvalidToken
+	}
+
+	user := &data.User{
+		ID:        userID,
+		Activated: claims.Activated,
+	}
+
+	return user, claims, nil
+}
+
+// The jwksHandler serves the public keys used to sign authentication tokens, so that
+// other services can verify them and keys can be rotated without coordination.
+func (app *application) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.config.jwt.enabled {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "public, max-age=300")
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"keys": app.jwtKeys.JWKS()}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createTokenRevocationHandler adds an entry to the JWT denylist. It's intended
+// for emergencies: either a single leaked token is revoked by its ID, or every token
+// issued to a user so far is revoked. Without stateless authentication there are no
+// JWTs to revoke, so it's a 404.
+func (app *application) createTokenRevocationHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.config.jwt.enabled {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		JTI    *string `json:"jti"`
+		UserID *int64  `json:"user_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.JTI != nil || input.UserID != nil, "jti", "either jti or user_id must be provided")
+	v.Check(input.JTI == nil || input.UserID == nil, "jti", "must not be provided together with user_id")
+	if input.JTI != nil {
+		v.Check(*input.JTI != "", "jti", "must not be empty")
+	}
+	if input.UserID != nil {
+		v.Check(*input.UserID > 0, "user_id", "must be a positive integer")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if input.UserID != nil {
+		_, err = app.models.Users.Get(*input.UserID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("user_id", "no user with this ID")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	// No token can outlive the configured TTL, so the revocation doesn't need to be
+	// kept around any longer than that.
+	revocation := &data.Revocation{
+		JTI:    input.JTI,
+		UserID: input.UserID,
+		Expiry: time.Now().Add(app.config.jwt.ttl),
+	}
+
+	err = app.models.Revocations.Insert(revocation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.denylist.add(revocation)
+
+	var subjectID int64
+	details := map[string]string{"scope": "jwt"}
+	if input.UserID != nil {
+		subjectID = *input.UserID
+	} else {
+		details["jti"] = *input.JTI
+	}
+	app.audit(r, data.AuditTokenRevoked, subjectID, details)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"revocation": revocation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
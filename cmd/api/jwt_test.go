@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/jwt"
+)
+
+func TestDenylist(t *testing.T) {
+	now := time.Now()
+	jti := "leaked"
+	userID := int64(7)
+
+	d := newDenylist()
+	d.add(&data.Revocation{JTI: &jti, Expiry: now.Add(time.Hour)})
+	d.add(&data.Revocation{UserID: &userID, RevokedAt: now, Expiry: now.Add(time.Hour)})
+
+	tests := []struct {
+		name     string
+		jti      string
+		userID   int64
+		issuedAt time.Time
+		want     bool
+	}{
+		{"revoked token", "leaked", 1, now.Add(-time.Minute), true},
+		{"other token", "other", 1, now.Add(-time.Minute), false},
+		{"issued before the user was revoked", "other", userID, now.Add(-time.Minute), true},
+		{"issued as the user was revoked", "other", userID, now, true},
+		{"issued after the user was revoked", "other", userID, now.Add(time.Second), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.revoked(tt.jti, tt.userID, tt.issuedAt); got != tt.want {
+				t.Errorf("got %t; want %t", got, tt.want)
+			}
+		})
+	}
+
+	d.replace(nil)
+	if d.revoked("leaked", userID, now.Add(-time.Minute)) {
+		t.Error("got revoked after replacing the denylist with nothing")
+	}
+}
+
+// A user-wide revocation has to survive the user being deleted: verifying a JWT never
+// checks that its user still exists.
+func TestUserRevocationOutlivesUser(t *testing.T) {
+	app := newTestApplication(t, newTestDB(t))
+
+	keys, err := jwt.ParseKeys(jwt.AlgEdDSA, "k1:"+base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.config.jwt.enabled = true
+	app.config.jwt.issuer = "greenlight"
+	app.config.jwt.ttl = time.Hour
+	app.jwtKeys = keys
+	app.denylist = newDenylist()
+
+	h := app.routes()
+
+	admin := newTestUser(t, app, "admin@example.com", "tokens:revoke")
+	user := newTestUser(t, app, "alice@example.com", "movies:read")
+
+	token, err := app.newStatelessAuthenticationToken(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tokens issued in the same second as the revocation count as revoked.
+	r := withBearer(httptest.NewRequest(http.MethodPost, "/v1/tokens/revocations", strings.NewReader(`{"user_id": `+strconv.FormatInt(user.ID, 10)+`}`)), newTestToken(t, app, admin))
+	res, body := do(t, h, r)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("got status %d; want %d (%v)", res.StatusCode, http.StatusCreated, body)
+	}
+
+	err = app.models.Users.Delete(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.loadDenylist()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = app.userForJWT(token.Plaintext)
+	if err == nil {
+		t.Error("got a deleted user's revoked JWT accepted")
+	}
+
+	// Unknown users are still refused.
+	r = withBearer(httptest.NewRequest(http.MethodPost, "/v1/tokens/revocations", strings.NewReader(`{"user_id": 999999}`)), newTestToken(t, app, admin))
+	res, _ = do(t, h, r)
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("unknown user: got status %d; want %d", res.StatusCode, http.StatusUnprocessableEntity)
+	}
+}
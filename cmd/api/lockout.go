@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
+)
+
+const (
+	// The first few failed logins are free. After that each failure doubles the wait
+	// before the next attempt, starting at loginBackoffBase.
+	loginFreeFailures = 3
+	loginBackoffBase  = time.Second
+	// Failures are forgotten once there haven't been any for this long.
+	loginFailureWindow = 24 * time.Hour
+)
+
+// loginKeys returns the keys failed logins are tracked under: one for the account and
+// one for the client IP, so that an attacker can neither hammer one account from many
+// addresses nor spray many accounts from one address.
+func (app *application) loginKeys(r *http.Request, email string) (accountKey, ipKey string) {
+	accountKey = "email:" + strings.ToLower(email)
+	ipKey = "ip:" + app.clientIP(r).String()
+	return accountKey, ipKey
+}
+
+// loginRetryAfter returns how long the client has to wait before it may try to log in
+// with the given keys again, or zero if it may try now.
+func (app *application) loginRetryAfter(keys ...string) (time.Duration, error) {
+	all, err := app.models.LoginFailures.Get(keys...)
+	if err != nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+
+	for _, failures := range all {
+		if remaining := time.Until(failures.LockedUntil); remaining > wait {
+			wait = remaining
+		}
+	}
+
+	return wait, nil
+}
+
+// recordLoginFailure counts a failed login against the account and the client IP and
+// backs both off accordingly. When the account reaches the maximum number of failures
+// it is locked out, and if it exists its owner is sent an email with a link to unlock
+// it early (someone else may have been trying to get in, and locked them out).
+func (app *application) recordLoginFailure(r *http.Request, accountKey, ipKey string, user *data.User) error {
+	var subjectID int64
+	if user != nil {
+		subjectID = user.ID
+	}
+
+	app.audit(r, data.AuditLoginFailed, subjectID, map[string]string{"account": accountKey})
+
+	failures, err := app.models.LoginFailures.RecordFailure(accountKey, loginFailureWindow)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.LoginFailures.Lock(accountKey, time.Now().Add(app.loginBackoff(failures, app.config.lockout.maxFailures)))
+	if err != nil {
+		return err
+	}
+
+	if failures >= app.config.lockout.maxFailures && user != nil {
+		err = app.sendUnlockEmail(r, user)
+		if err != nil {
+			return err
+		}
+	}
+
+	failures, err = app.models.LoginFailures.RecordFailure(ipKey, loginFailureWindow)
+	if err != nil {
+		return err
+	}
+
+	return app.models.LoginFailures.Lock(ipKey, time.Now().Add(app.loginBackoff(failures, app.config.lockout.ipMaxFailures)))
+}
+
+// loginBackoff returns how long to wait after the given number of consecutive failures.
+func (app *application) loginBackoff(failures, maxFailures int) time.Duration {
+	switch {
+	case failures >= maxFailures:
+		return app.config.lockout.duration
+	case failures < loginFreeFailures:
+		return 0
+	case failures-loginFreeFailures > 30:
+		return app.config.lockout.duration
+	}
+
+	wait := loginBackoffBase << (failures - loginFreeFailures)
+	if wait > app.config.lockout.duration {
+		return app.config.lockout.duration
+	}
+
+	return wait
+}
+
+func (app *application) sendUnlockEmail(r *http.Request, user *data.User) error {
+	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeUnlock)
+	if err != nil {
+		return err
+	}
+
+	app.background(r, func() {
+		data := map[string]any{
+			"unlockToken": token.Plaintext,
+			"lockout":     app.config.lockout.duration.String(),
+		}
+
+		err := app.requestMailer(r).Send(user.Email, "account_locked.tmpl", data)
+		if err != nil {
+			app.requestLogger(r).PrintError(err, nil)
+		}
+	})
+
+	return nil
+}
+
+// The unlockAccountHandler lifts a lockout early, using the token from the email that
+// was sent when the account was locked.
+func (app *application) unlockAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeUnlock, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired unlock token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	accountKey, _ := app.loginKeys(r, user.Email)
+
+	err = app.models.LoginFailures.Clear(accountKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeUnlock, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your account has been unlocked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	app := newTestApplication(t, nil)
+	app.config.lockout.maxFailures = 10
+	app.config.lockout.duration = time.Minute
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{6, 8 * time.Second},
+		{9, time.Minute},
+		{10, time.Minute},
+		{100, time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := app.loginBackoff(tt.failures, app.config.lockout.maxFailures); got != tt.want {
+			t.Errorf("after %d failures: got %s; want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	app := newTestApplication(t, newTestDB(t))
+	app.config.lockout.maxFailures = 3
+	h := app.routes()
+
+	user := newTestUser(t, app, "alice@example.com", "movies:read")
+
+	login := func(password string) *http.Response {
+		r := httptest.NewRequest(http.MethodPost, "/v1/tokens/authentication", strings.NewReader(`{"email": "alice@example.com", "password": "`+password+`"}`))
+		res, _ := do(t, h, r)
+		return res
+	}
+
+	for i := 1; i <= app.config.lockout.maxFailures; i++ {
+		if res := login("wrong password"); res.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("failed login %d: got status %d; want %d", i, res.StatusCode, http.StatusUnauthorized)
+		}
+	}
+
+	// Once locked, even the right password is refused without being checked.
+	res := login("pa55word1234")
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("locked account: got status %d; want %d", res.StatusCode, http.StatusTooManyRequests)
+	}
+	if res.Header.Get("Retry-After") == "" {
+		t.Error("locked account: got no Retry-After header")
+	}
+
+	token, err := app.models.Tokens.New(user.ID, time.Hour, data.ScopeUnlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, body := do(t, h, httptest.NewRequest(http.MethodPost, "/v1/tokens/unlock", strings.NewReader(`{"token": "`+token.Plaintext+`"}`)))
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unlocking: got status %d (%v)", res.StatusCode, body)
+	}
+
+	// Unlocking only lifts the account's lockout; the client IP is backed off on its
+	// own, and would have to wait a second.
+	err = app.models.LoginFailures.Clear("ip:192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res := login("pa55word1234"); res.StatusCode != http.StatusCreated {
+		t.Errorf("unlocked account: got status %d; want %d", res.StatusCode, http.StatusCreated)
+	}
+}
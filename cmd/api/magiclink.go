@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
+)
+
+const (
+	magicLinkTTL = 15 * time.Minute
+	// At most this many unused magic links can be outstanding for an address at once,
+	// so the endpoint can't be used to flood someone's inbox.
+	magicLinkMaxActive = 3
+)
+
+// The createMagicLinkTokenHandler emails a single-use login token to the address. Like
+// the password reset endpoint it responds the same way whatever happens, including when
+// the address is throttled, so it can't be used to find out which addresses have
+// accounts.
+func (app *application) createMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user != nil && user.Activated {
+		err = app.sendMagicLink(r, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	env := envelope{"message": "if the address belongs to an activated account, an email will be sent to it containing a login token"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) sendMagicLink(r *http.Request, user *data.User) error {
+	active, err := app.models.Tokens.CountActiveForUser(data.ScopeMagicLink, user.ID)
+	if err != nil {
+		return err
+	}
+
+	if active >= magicLinkMaxActive {
+		app.requestLogger(r).PrintInfo("magic link throttled", map[string]string{"user_id": strconv.FormatInt(user.ID, 10)})
+		return nil
+	}
+
+	token, err := app.models.Tokens.New(user.ID, magicLinkTTL, data.ScopeMagicLink)
+	if err != nil {
+		return err
+	}
+
+	app.background(r, func() {
+		data := map[string]any{
+			"email":          user.Email,
+			"magicLinkToken": token.Plaintext,
+		}
+
+		err := app.requestMailer(r).Send(user.Email, "magic_link.tmpl", data)
+		if err != nil {
+			app.requestLogger(r).PrintError(err, nil)
+		}
+	})
+
+	return nil
+}
+
+// The exchangeMagicLinkTokenHandler logs the user in with a token from a magic link
+// email. The address has to be given along with the token, so that failed attempts
+// can be counted against it exactly like failed password logins are.
+func (app *application) exchangeMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email          string `json:"email"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	accountKey, ipKey := app.loginKeys(r, input.Email)
+
+	retryAfter, err := app.loginRetryAfter(accountKey, ipKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.loginLockedResponse(w, r, retryAfter)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeMagicLink, input.TokenPlaintext)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user == nil || !strings.EqualFold(user.Email, input.Email) {
+		err = app.recordLoginFailure(r, accountKey, ipKey, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	// Using one link uses them all up.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeMagicLink, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.LoginFailures.Clear(accountKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
@@ -3,17 +3,25 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"runtime"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
 	"github.com/TomiwaAribisala-git/greenlight.git/internal/jsonlog"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/jwt"
 	"github.com/TomiwaAribisala-git/greenlight.git/internal/mailer"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/oidc"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/passhash"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/passpolicy"
 	_ "github.com/lib/pq"
 )
 
@@ -31,9 +39,16 @@ type config struct {
 		maxIdleTime  string
 	}
 	limiter struct {
-		rps     float64
-		burst   int
-		enabled bool
+		rps        float64
+		burst      int
+		writeRPS   float64
+		writeBurst int
+		authRPS    float64
+		authBurst  int
+		enabled    bool
+		backend    string
+		lease      int
+		failClosed bool
 	}
 	smtp struct {
 		host     string
@@ -42,6 +57,65 @@ type config struct {
 		password string
 		sender   string
 	}
+	jwt struct {
+		enabled         bool
+		alg             string
+		keys            string
+		issuer          string
+		ttl             time.Duration
+		denylistRefresh time.Duration
+	}
+	oauth struct {
+		issuer   string
+		tokenTTL time.Duration
+		codeTTL  time.Duration
+	}
+	oidc struct {
+		issuer       string
+		clientID     string
+		clientSecret string
+		redirectURL  string
+	}
+	password struct {
+		hasher     string
+		minLength  int
+		minScore   int
+		breachFile string
+	}
+	lockout struct {
+		maxFailures   int
+		ipMaxFailures int
+		duration      time.Duration
+	}
+	permissions struct {
+		anonymous    string
+		registration string
+		activation   string
+	}
+	registration struct {
+		mode         string
+		domains      []string
+		passwordless bool
+	}
+	privacy struct {
+		exportTTL     time.Duration
+		deletionGrace time.Duration
+		deletionMode  string
+	}
+	audit struct {
+		retention time.Duration
+	}
+	cors struct {
+		trustedOrigins []string
+		maxAge         time.Duration
+	}
+	accessLog struct {
+		enabled bool
+		sample  float64
+		file    string
+	}
+	// trustedProxies are the reverse proxies whose X-Forwarded-For headers are believed.
+	trustedProxies []*net.IPNet
 }
 
 // application struct to hold dependencies for HTTP handlers, helpers and middleware
@@ -49,9 +123,21 @@ type application struct {
 	config config
 	//logger *log.Logger
 	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	// accessLogger is where the access log goes: logger, unless -access-log-file is set.
+	accessLogger *jsonlog.Logger
+	// metricSet holds the metrics served at GET /metrics.
+	metricSet *appMetrics
+	models    data.Models
+	mailer    mailer.Mailer
+	wg        sync.WaitGroup
+	// jwtKeys and denylist are only set when stateless authentication is enabled.
+	jwtKeys  *jwt.KeySet
+	denylist *denylist
+	// limiter holds the rate limiter's buckets. It's set up once, in main(), rather
+	// than in routes(), so that there's only ever one eviction goroutine.
+	limiter *rateLimiter
+	// oidc is only set when single sign-on with an external provider is configured.
+	oidc *oidc.Provider
 }
 
 func main() {
@@ -69,14 +155,134 @@ func main() {
 
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	flag.Float64Var(&cfg.limiter.writeRPS, "limiter-write-rps", 1, "Rate limiter maximum requests per second for writes")
+	flag.IntVar(&cfg.limiter.writeBurst, "limiter-write-burst", 4, "Rate limiter maximum burst for writes")
+	flag.Float64Var(&cfg.limiter.authRPS, "limiter-auth-rps", 0.2, "Rate limiter maximum requests per second for login, registration and token endpoints")
+	flag.IntVar(&cfg.limiter.authBurst, "limiter-auth-burst", 5, "Rate limiter maximum burst for login, registration and token endpoints")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
 
+	// The postgres backend shares the limits between every instance of the API, instead
+	// of each instance allowing the full rate.
+	flag.StringVar(&cfg.limiter.backend, "limiter-backend", limiterMemory, "Where rate limits are kept (memory|postgres)")
+	flag.IntVar(&cfg.limiter.lease, "limiter-lease", 5, "Tokens taken from the postgres backend at a time (capped at each limit's burst)")
+	flag.BoolVar(&cfg.limiter.failClosed, "limiter-fail-closed", false, "Reject requests when the postgres backend can't be reached")
+
+	// Origins have to match exactly, scheme and port included, e.g.
+	// "https://www.example.com" or "http://localhost:9000".
+	flag.Func("cors-trusted-origins", "Comma-separated origins of browser clients which can call the API", func(val string) error {
+		cfg.cors.trustedOrigins = strings.FieldsFunc(val, func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+		return nil
+	})
+	flag.DurationVar(&cfg.cors.maxAge, "cors-max-age", 10*time.Minute, "How long browsers can cache CORS preflight responses")
+
+	// Requests from a trusted proxy are taken to be from the client it says it's
+	// forwarding for, which matters for rate limiting, lockouts and API key IP
+	// restrictions. Never list addresses that clients can connect from directly.
+	flag.Func("trusted-proxies", "Comma-separated IP addresses or CIDR ranges of trusted reverse proxies", func(val string) error {
+		for _, proxy := range strings.Split(val, ",") {
+			proxy = strings.TrimSpace(proxy)
+			if proxy == "" {
+				continue
+			}
+
+			if !strings.Contains(proxy, "/") {
+				if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+					proxy += "/32"
+				} else {
+					proxy += "/128"
+				}
+			}
+
+			_, network, err := net.ParseCIDR(proxy)
+			if err != nil {
+				return err
+			}
+
+			cfg.trustedProxies = append(cfg.trustedProxies, network)
+		}
+		return nil
+	})
+
 	flag.StringVar(&cfg.smtp.host, "smtp-host", "sandbox.smtp.mailtrap.io", "SMTP host")
 	flag.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
 	flag.StringVar(&cfg.smtp.username, "smtp-username", "37b331b8503719", "SMTP username")
 	flag.StringVar(&cfg.smtp.password, "smtp-password", "9ed24e0f21b21e", "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.tomiwa.net>", "SMTP sender")
 
+	// Stateless authentication: the first key in -jwt-keys signs new tokens, and all of
+	// them are accepted when verifying, which is how keys are rotated.
+	flag.BoolVar(&cfg.jwt.enabled, "jwt-enabled", false, "Issue stateless JWT authentication tokens")
+	flag.StringVar(&cfg.jwt.alg, "jwt-alg", jwt.AlgEdDSA, "JWT signing algorithm (EdDSA|HS256)")
+	flag.StringVar(&cfg.jwt.keys, "jwt-keys", os.Getenv("GREENLIGHT_JWT_KEYS"), "JWT signing keys as comma-separated kid:base64key entries")
+	flag.StringVar(&cfg.jwt.issuer, "jwt-issuer", "greenlight", "JWT issuer claim")
+	flag.DurationVar(&cfg.jwt.ttl, "jwt-ttl", 24*time.Hour, "JWT authentication token lifetime")
+	flag.DurationVar(&cfg.jwt.denylistRefresh, "jwt-denylist-refresh", 30*time.Second, "Interval between reloads of the JWT revocation deny-list")
+
+	// The issuer URL is configured rather than taken from the Host header, to avoid
+	// host header injection into the authorization server metadata.
+	flag.StringVar(&cfg.oauth.issuer, "oauth-issuer", "http://localhost:4000", "OAuth authorization server issuer URL")
+	flag.DurationVar(&cfg.oauth.tokenTTL, "oauth-token-ttl", time.Hour, "OAuth access token lifetime")
+	flag.DurationVar(&cfg.oauth.codeTTL, "oauth-code-ttl", 10*time.Minute, "OAuth authorization code lifetime")
+
+	// Single sign-on is enabled by setting -oidc-issuer. The redirect URL must point at
+	// GET /v1/oidc/callback and be registered with the provider.
+	flag.StringVar(&cfg.oidc.issuer, "oidc-issuer", "", "OpenID Connect provider issuer URL (enables single sign-on)")
+	flag.StringVar(&cfg.oidc.clientID, "oidc-client-id", "", "OpenID Connect client ID")
+	flag.StringVar(&cfg.oidc.clientSecret, "oidc-client-secret", os.Getenv("GREENLIGHT_OIDC_CLIENT_SECRET"), "OpenID Connect client secret")
+	flag.StringVar(&cfg.oidc.redirectURL, "oidc-redirect-url", "http://localhost:4000/v1/oidc/callback", "OpenID Connect redirect URL")
+
+	// Existing password hashes keep working whichever hasher is chosen, and are rehashed
+	// with it the next time their owner logs in.
+	flag.StringVar(&cfg.password.hasher, "password-hasher", "argon2id", "Password hashing algorithm for new hashes (argon2id|bcrypt)")
+
+	// The policy applies whenever a password is set. The breach file lists SHA-1 hashes
+	// (or their first 16 hex digits) of known breached passwords, one per line.
+	flag.IntVar(&cfg.password.minLength, "password-min-length", 8, "Minimum length of new passwords")
+	flag.IntVar(&cfg.password.minScore, "password-min-score", 2, "Minimum strength score of new passwords (0-4)")
+	flag.StringVar(&cfg.password.breachFile, "password-breach-file", "", "File of breached password SHA-1 hashes to reject")
+
+	// Failed logins are tracked per account and per client IP. Each failure after the
+	// first few doubles the wait before the next attempt, and at the maximum the
+	// account (or IP) is locked out for -login-lockout.
+	flag.IntVar(&cfg.lockout.maxFailures, "login-max-failures", 10, "Failed logins before an account is locked out")
+	flag.IntVar(&cfg.lockout.ipMaxFailures, "login-ip-max-failures", 100, "Failed logins before a client IP is locked out")
+	flag.DurationVar(&cfg.lockout.duration, "login-lockout", 15*time.Minute, "How long a lockout lasts")
+
+	// Comma-separated permission codes. Anonymous permissions are held by every request,
+	// authenticated or not, so for example "movies:read" opens up the movie catalogue.
+	flag.StringVar(&cfg.permissions.anonymous, "anonymous-permissions", "", "Permissions of unauthenticated requests")
+	flag.StringVar(&cfg.permissions.registration, "registration-permissions", "movies:read", "Permissions granted to new users")
+	flag.StringVar(&cfg.permissions.activation, "activation-permissions", "", "Permissions granted to users when they activate their account")
+
+	// In "domains" mode only addresses at the listed domains can register, and in
+	// "invitation" mode only people invited through POST /v1/invitations. Invitations
+	// work in every mode.
+	flag.StringVar(&cfg.registration.mode, "registration-mode", registrationOpen, "Who can register (open|domains|invitation)")
+	flag.Func("registration-domains", "Comma-separated email domains which can register in domains mode", func(val string) error {
+		for _, domain := range strings.Split(val, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				cfg.registration.domains = append(cfg.registration.domains, strings.ToLower(domain))
+			}
+		}
+		return nil
+	})
+	flag.BoolVar(&cfg.registration.passwordless, "registration-passwordless", false, "Let users register without a password and log in with magic links")
+
+	// Accounts are deleted -account-deletion-grace after their owner asks, unless they
+	// log in before then. "anonymise" keeps the users row, stripped of anything
+	// personal, so the movies the user created keep an owner.
+	flag.DurationVar(&cfg.privacy.exportTTL, "data-export-ttl", 48*time.Hour, "How long a data export can be downloaded for")
+	flag.DurationVar(&cfg.privacy.deletionGrace, "account-deletion-grace", 30*24*time.Hour, "Time between a deletion request and the account being deleted")
+	flag.StringVar(&cfg.privacy.deletionMode, "account-deletion-mode", deletionAnonymise, "What happens to deleted accounts (anonymise|delete)")
+
+	flag.DurationVar(&cfg.audit.retention, "audit-retention", 365*24*time.Hour, "How long audit events are kept for (0 keeps them forever)")
+
+	flag.BoolVar(&cfg.accessLog.enabled, "access-log", true, "Log every request")
+	flag.Float64Var(&cfg.accessLog.sample, "access-log-sample", 1, "Fraction of successful requests to log (failed requests are always logged)")
+	flag.StringVar(&cfg.accessLog.file, "access-log-file", "", "File to write the access log to, instead of standard output")
+
 	// Create a new version boolean flag with the default value of false.
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
@@ -92,6 +298,58 @@ func main() {
 	// logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	switch cfg.registration.mode {
+	case registrationOpen, registrationDomains, registrationInvitation:
+	default:
+		logger.PrintFatal(fmt.Errorf("invalid registration mode %q", cfg.registration.mode), nil)
+	}
+
+	if cfg.limiter.enabled {
+		for _, limit := range []struct {
+			rps   float64
+			burst int
+		}{
+			{cfg.limiter.rps, cfg.limiter.burst},
+			{cfg.limiter.writeRPS, cfg.limiter.writeBurst},
+			{cfg.limiter.authRPS, cfg.limiter.authBurst},
+		} {
+			if limit.rps <= 0 || limit.burst <= 0 {
+				logger.PrintFatal(errors.New("rate limiter rps and burst values must be greater than zero"), nil)
+			}
+		}
+	}
+
+	switch cfg.limiter.backend {
+	case limiterMemory, limiterPostgres:
+	default:
+		logger.PrintFatal(fmt.Errorf("invalid rate limiter backend %q", cfg.limiter.backend), nil)
+	}
+
+	if cfg.limiter.lease < 1 {
+		logger.PrintFatal(errors.New("rate limiter lease must be at least 1"), nil)
+	}
+
+	switch cfg.privacy.deletionMode {
+	case deletionAnonymise, deletionDelete:
+	default:
+		logger.PrintFatal(fmt.Errorf("invalid account deletion mode %q", cfg.privacy.deletionMode), nil)
+	}
+
+	if cfg.accessLog.sample < 0 || cfg.accessLog.sample > 1 {
+		logger.PrintFatal(errors.New("access log sample must be between 0 and 1"), nil)
+	}
+
+	accessLogger := logger
+	if cfg.accessLog.file != "" {
+		file, err := os.OpenFile(cfg.accessLog.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		defer file.Close()
+
+		accessLogger = jsonlog.New(file, jsonlog.LevelInfo)
+	}
+
 	db, err := openDB(cfg)
 	if err != nil {
 		//logger.Fatal(err)
@@ -126,11 +384,78 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	metricSet := newAppMetrics(db)
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:       cfg,
+		logger:       logger,
+		accessLogger: accessLogger,
+		metricSet:    metricSet,
+		models:       data.NewModels(db),
+		mailer:       mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender).WithSendHook(metricSet.emailSent),
+	}
+
+	if cfg.jwt.enabled {
+		app.jwtKeys, err = jwt.ParseKeys(cfg.jwt.alg, cfg.jwt.keys)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		// Load the deny-list before serving any requests, so that a restart never
+		// briefly accepts revoked tokens.
+		app.denylist = newDenylist()
+		err = app.loadDenylist()
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		go app.refreshDenylist()
+	}
+
+	app.limiter = app.newRateLimiter()
+	if cfg.limiter.enabled {
+		go app.limiter.evict()
+	}
+
+	go app.processAccountDeletions()
+
+	if cfg.audit.retention > 0 {
+		go app.purgeAuditEvents()
+	}
+
+	if cfg.oidc.issuer != "" {
+		app.oidc = oidc.New(cfg.oidc.issuer, cfg.oidc.clientID, cfg.oidc.clientSecret, cfg.oidc.redirectURL)
+	}
+
+	data.PasswordHasher, err = passhash.New(cfg.password.hasher)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	data.PasswordPolicy.MinLength = cfg.password.minLength
+	data.PasswordPolicy.MinScore = cfg.password.minScore
+
+	if cfg.password.breachFile != "" {
+		data.PasswordPolicy.Breached, err = passpolicy.LoadCorpusFile(cfg.password.breachFile)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		logger.PrintInfo("breached password corpus loaded", map[string]string{
+			"passwords": strconv.Itoa(data.PasswordPolicy.Breached.Len()),
+		})
+	}
+
+	data.DefaultPermissions = data.PermissionDefaults{
+		Anonymous:    permissionList(cfg.permissions.anonymous),
+		Registration: permissionList(cfg.permissions.registration),
+		Activation:   permissionList(cfg.permissions.activation),
+	}
+
+	// Catch typos now rather than when the first user registers.
+	err = app.checkDefaultPermissions()
+	if err != nil {
+		logger.PrintFatal(err, nil)
 	}
 
 	/*
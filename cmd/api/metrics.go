@@ -0,0 +1,86 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/metrics"
+)
+
+// appMetrics holds the metrics served at GET /metrics. They're registered once, in
+// main(), so that building the routes again doesn't register them twice.
+type appMetrics struct {
+	registry *metrics.Registry
+
+	requests          *metrics.CounterVec
+	requestDuration   *metrics.HistogramVec
+	requestsInFlight  *metrics.Gauge
+	rateLimited       *metrics.CounterVec
+	backgroundTasks   *metrics.CounterVec
+	backgroundRunning *metrics.Gauge
+	emails            *metrics.CounterVec
+}
+
+func newAppMetrics(db *sql.DB) *appMetrics {
+	registry := metrics.New()
+
+	m := &appMetrics{
+		registry: registry,
+
+		requests:          registry.NewCounterVec("greenlight_http_requests_total", "Requests handled, by method, route and status.", "method", "route", "status"),
+		requestDuration:   registry.NewHistogramVec("greenlight_http_request_duration_seconds", "Time taken to handle requests, by method, route and status.", metrics.DefaultBuckets, "method", "route", "status"),
+		requestsInFlight:  registry.NewGauge("greenlight_http_requests_in_flight", "Requests being handled."),
+		rateLimited:       registry.NewCounterVec("greenlight_rate_limited_requests_total", "Requests turned away by the rate limiter, by route class.", "class"),
+		backgroundTasks:   registry.NewCounterVec("greenlight_background_tasks_total", "Background tasks finished, by outcome (completed or panicked).", "outcome"),
+		backgroundRunning: registry.NewGauge("greenlight_background_tasks_running", "Background tasks running."),
+		emails:            registry.NewCounterVec("greenlight_emails_total", "Emails sent, by template and outcome (sent or failed).", "template", "outcome"),
+	}
+
+	registry.NewGaugeFunc("greenlight_db_open_connections", "Open database connections, in use or idle.", func() float64 {
+		return float64(db.Stats().OpenConnections)
+	})
+	registry.NewGaugeFunc("greenlight_db_in_use_connections", "Database connections in use.", func() float64 {
+		return float64(db.Stats().InUse)
+	})
+	registry.NewGaugeFunc("greenlight_db_idle_connections", "Idle database connections.", func() float64 {
+		return float64(db.Stats().Idle)
+	})
+	registry.NewGaugeFunc("greenlight_db_max_open_connections", "Maximum number of open database connections.", func() float64 {
+		return float64(db.Stats().MaxOpenConnections)
+	})
+	registry.NewCounterFunc("greenlight_db_wait_count_total", "Times a request waited for a database connection.", func() float64 {
+		return float64(db.Stats().WaitCount)
+	})
+	registry.NewCounterFunc("greenlight_db_wait_duration_seconds_total", "Time spent waiting for database connections.", func() float64 {
+		return db.Stats().WaitDuration.Seconds()
+	})
+	registry.NewCounterFunc("greenlight_db_max_idle_closed_total", "Connections closed because of -db-max-idle-conns.", func() float64 {
+		return float64(db.Stats().MaxIdleClosed)
+	})
+	registry.NewCounterFunc("greenlight_db_max_idle_time_closed_total", "Connections closed because of -db-max-idle-time.", func() float64 {
+		return float64(db.Stats().MaxIdleTimeClosed)
+	})
+
+	return m
+}
+
+// emailSent is the mailer's send hook.
+func (m *appMetrics) emailSent(templateFile string, err error) {
+	outcome := "sent"
+	if err != nil {
+		outcome = "failed"
+	}
+	m.emails.Inc(templateFile, outcome)
+}
+
+// metricsMethod keeps the method label to the standard methods, so that clients can't
+// make up new series by sending requests with made-up methods.
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+		return method
+	default:
+		return "OTHER"
+	}
+}
@@ -3,18 +3,66 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
-	"expvar"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/jwt"
 	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
-	"golang.org/x/time/rate"
+	"github.com/julienschmidt/httprouter"
 )
 
+// requestID gives every request an ID, which is sent back in the X-Request-ID header
+// so that a request can be matched up with the log entries, audit events and emails it
+// left behind. An X-Request-ID sent with the request, say by a load balancer, is kept
+// if it looks like an ID; otherwise a random one is made up.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+
+		if !validRequestID(id) {
+			b := make([]byte, 8)
+			_, err := rand.Read(b)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		next.ServeHTTP(w, app.contextSetRequestID(r, id))
+	})
+}
+
+// validRequestID accepts IDs of up to 64 letters, digits, dashes, underscores and dots,
+// which covers UUIDs and the IDs proxies generate, and keeps anything which could mess
+// up a log line or an email header out.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
@@ -36,93 +84,92 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) rateLimit(next http.Handler) http.Handler {
+// newRateLimiter sets up the limiter used by rateLimitFailures() and rateLimit().
+func (app *application) newRateLimiter() *rateLimiter {
+	limiter := newRateLimiter(map[string]limit{
+		rateLimitRead:       {rps: app.config.limiter.rps, burst: app.config.limiter.burst},
+		rateLimitWrite:      {rps: app.config.limiter.writeRPS, burst: app.config.limiter.writeBurst},
+		rateLimitAuth:       {rps: app.config.limiter.authRPS, burst: app.config.limiter.authBurst},
+		rateLimitFailedAuth: {rps: app.config.limiter.authRPS, burst: app.config.limiter.authBurst},
+	})
 
-	limiter := rate.NewLimiter(2, 4)
+	if app.config.limiter.backend == limiterPostgres {
+		limiter.shared = &app.models.RateLimits
+		limiter.lease = app.config.limiter.lease
+		limiter.failClosed = app.config.limiter.failClosed
+		limiter.logger = app.logger
+	}
+
+	return limiter
+}
 
-	// The function we are returning is a closure, which 'closes over' the limiter variable.
+// rateLimitFailures limits, per IP address, the requests which end in a 401, such as
+// those with a made-up bearer token, API key or JWT. It runs before authenticate(), so
+// that once an address has used up its failed-auth bucket, its requests are turned
+// away before their credentials are looked up.
+func (app *application) rateLimitFailures(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Call limiter.Allow() to see if the request is permitted, and if it's not,
-		// then we call the rateLimitExceededResponse() helper to return a 429 Too Many
-		// Requests response (we will create this helper in a minute).
-		if !limiter.Allow() {
+		if !app.config.limiter.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := app.rateLimitIPKey(r)
+
+		if result, exhausted := app.limiter.exhausted(rateLimitFailedAuth, key); exhausted {
+			setRateLimitHeaders(w, result)
+			app.metricSet.rateLimited.Inc(rateLimitFailedAuth)
 			app.rateLimitExceededResponse(w, r)
 			return
 		}
-		next.ServeHTTP(w, r)
-	})
-}
 
-// Global Rate Limiter
-/*
-func (app *application) rateLimit(next http.Handler) http.Handler {
+		rr := newResponseRecorder(w)
 
-	// Define a client struct to hold the rate limiter and last seen time for each
-	// client.
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
-	}
+		next.ServeHTTP(rr, r)
 
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
-
-	// Launch a background goroutine which removes old entries from the clients map once
-	// every minute.
-	go func() {
-		for {
-			time.Sleep(time.Minute)
-			// Lock the mutex to prevent any rate limiter checks from happening while
-			// the cleanup is taking place.
-			mu.Lock()
-			// Loop through all clients. If they haven't been seen within the last three
-			// minutes, delete the corresponding entry from the map.
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
-			}
-			// Importantly, unlock the mutex when the cleanup is complete.
-			mu.Unlock()
+		if rr.status == http.StatusUnauthorized {
+			app.limiter.allow(rateLimitFailedAuth, key)
 		}
-	}()
+	})
+}
 
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Only carry out the check if rate limiting is enabled.
-			if app.config.limiter.enabled {
-				ip, _, err := net.SplitHostPort(r.RemoteAddr)
-				if err != nil {
-					app.serverErrorResponse(w, r, err)
-					return
-				}
+// rateLimit limits requests per user for authenticated requests, and per IP address
+// for anonymous ones, with separate limits for each route class (see rateLimitClass).
+// It has to run after authenticate(), so that it knows who the user is; requests with
+// bad credentials never get this far, and are limited by rateLimitFailures() instead.
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.limiter.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-				mu.Lock()
+		key := app.rateLimitIPKey(r)
+		if user := app.contextGetUser(r); !user.IsAnonymous() {
+			key = "user:" + strconv.FormatInt(user.ID, 10)
+		}
 
-				if _, found := clients[ip]; !found {
-					clients[ip] = &client{
-					// Use the requests-per-second and burst values from the config
-					// struct.
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
-				}
-			}
+		class := rateLimitClass(r)
+		result := app.limiter.allow(class, key)
 
-			clients[ip].lastSeen = time.Now()
+		setRateLimitHeaders(w, result)
 
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
-				return
-			}
-
-			mu.Unlock()
+		if !result.allowed {
+			app.metricSet.rateLimited.Inc(class)
+			app.rateLimitExceededResponse(w, r)
+			return
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
-*/
+
+func (app *application) rateLimitIPKey(r *http.Request) string {
+	if ip := app.clientIP(r); ip != nil {
+		return "ip:" + ip.String()
+	}
+	return "ip:" + r.RemoteAddr
+}
 
 // once a client has an authentication token we will expect them to include it with
 // all subsequent requests in an Authorization header
@@ -159,6 +206,15 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		// The OAuth token, introspection and revocation endpoints authenticate clients
+		// themselves, usually with HTTP Basic (RFC 6749 section 2.3.1), so the header is
+		// left for them to check.
+		if strings.HasPrefix(authorizationHeader, "Basic ") && oauthClientEndpoints[r.URL.Path] {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// Otherwise, we expect the value of the Authorization header to be in the format
 		// "Bearer <token>". We try to split this into its constituent parts, and if the
 		// header isn't in the expected format we return a 401 Unauthorized response
@@ -173,6 +229,52 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// Extract the actual authentication token from the header parts.
 		token := headerParts[1]
 
+		// When stateless authentication is enabled, JWTs are verified using the signing
+		// keys and the in-memory deny-list alone, without touching the database.
+		if app.config.jwt.enabled && jwt.LooksLikeJWT(token) {
+			user, claims, err := app.userForJWT(token)
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetClaims(r, claims)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Personal API keys are recognisable by their prefix. They are looked up together
+		// with their owner, and are only accepted from the key's IP allowlist.
+		if strings.HasPrefix(token, data.APIKeyPrefix) {
+			v := validator.New()
+			if data.ValidateAPIKeyPlaintext(v, token); !v.Valid() {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			key, user, err := app.models.APIKeys.GetForPlaintext(token)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.invalidAuthenticationTokenResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			if !key.AllowsIP(app.clientIP(r)) {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetAPIKey(r, key)
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// Validate the token to make sure it is in a sensible format.
 		v := validator.New()
 		// If the token isn't valid, use the invalidAuthenticationTokenResponse()
@@ -183,7 +285,10 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		// Both authentication tokens and access tokens issued to OAuth clients are
+		// accepted here; the token is kept in the context so that requirePermission()
+		// can restrict OAuth clients to the permissions they were granted.
+		user, accessToken, err := app.models.Users.GetForAccessToken(token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -197,6 +302,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// Call the contextSetUser() helper to add the user information to the request
 		// context.
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, accessToken)
 
 		// Call the next handler in the chain.
 		next.ServeHTTP(w, r)
@@ -247,7 +353,7 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 		user := app.contextGetUser(r)
 
 		// Get the slice of permissions for the user.
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		permissions, err := app.permissionsForRequest(r, user)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -264,13 +370,120 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 		next.ServeHTTP(w, r)
 	}
 	// Wrap this with the requireActivatedUser() middleware before returning it.
-	return app.requireActivatedUser(fn)
+	protected := app.requireActivatedUser(fn)
+
+	// Routes needing a permission which everyone has, like GET /v1/movies when the
+	// deployment lets anonymous users read the catalogue, are open to all requests.
+	return func(w http.ResponseWriter, r *http.Request) {
+		if data.DefaultPermissions.Anonymous.Include(code) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		protected.ServeHTTP(w, r)
+	}
 }
 
-// CORS setting for all origins
+// permissionsForRequest returns the permissions of the request user. Requests made with
+// a JWT carry their permissions in the token claims, so only opaque tokens and API keys
+// need the database lookup. Neither an API key nor an OAuth client can ever do more
+// than the user, so their scopes are intersected with the user's current permissions.
+//
+// Whatever the credential, the result includes the anonymous permissions: a request
+// can always do what it could do without authenticating at all.
+func (app *application) permissionsForRequest(r *http.Request, user *data.User) (data.Permissions, error) {
+	anonymous := data.DefaultPermissions.Anonymous
+
+	if claims, ok := app.contextGetClaims(r); ok {
+		return data.Permissions(claims.Permissions).Union(anonymous), nil
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, ok := app.contextGetAPIKey(r); ok {
+		return permissions.Intersect(key.Scopes).Union(anonymous), nil
+	}
+
+	if token, ok := app.contextGetToken(r); ok && token.ClientID != nil {
+		return permissions.Intersect(token.Permissions).Union(anonymous), nil
+	}
+
+	return permissions, nil
+}
+
+// isDelegatedCredential reports whether the request was authenticated with a credential
+// which acts for the user with reduced rights, i.e. an API key, an OAuth access token or
+// an impersonation token. Such credentials must not be able to create further
+// credentials or change the user's security settings.
+func (app *application) isDelegatedCredential(r *http.Request) bool {
+	if _, ok := app.contextGetAPIKey(r); ok {
+		return true
+	}
+
+	token, ok := app.contextGetToken(r)
+	return ok && (token.ClientID != nil || token.ImpersonationID != nil)
+}
+
+// selfOr dispatches requests for a user's sub-resources. The authenticated user can
+// refer to themselves as "me" in the /v1/users/:id/... routes, and those requests go
+// to self; requests naming a user by ID go to other, which is usually an admin handler.
+// Either can be nil if the route only makes sense one way.
+func (app *application) selfOr(self, other http.HandlerFunc) http.HandlerFunc {
+	return app.routeByID(map[string]http.HandlerFunc{"me": self}, other)
+}
+
+// routeByID dispatches requests on the value of the :id parameter. httprouter won't
+// let a static segment such as "me" or "activated" sit next to ":id", so routes like
+// that are registered as :id and sent to the right handler here. Requests with any
+// other ID go to other.
+func (app *application) routeByID(routes map[string]http.HandlerFunc, other http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next, ok := routes[httprouter.ParamsFromContext(r.Context()).ByName("id")]
+		if !ok {
+			next = other
+		}
+
+		if next == nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// enableCORS lets browser clients on the -cors-trusted-origins call the API, with
+// credentials. The Origin header is reflected back only when it's exactly one of
+// them, and preflight requests from them are answered here, without going any further
+// down the chain. Requests from other origins are served as usual, but without CORS
+// headers, so the browser won't let the page read the response.
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		// The response depends on these request headers, so caches mustn't hand a
+		// response for one origin to another.
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+
+		origin := r.Header.Get("Origin")
+
+		if origin != "" && slices.Contains(app.config.cors.trustedOrigins, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Set("Access-Control-Expose-Headers", "Location, Retry-After, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, X-Request-ID")
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET, POST, PUT, PATCH, DELETE")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(app.config.cors.maxAge.Seconds())))
+
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
@@ -295,38 +508,28 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 // Content-Type: application/json
 
 // Request Level Metrics: 2521 μs / 1 reqs = 2521 μs/req = 2.5 s/req
-func (app *application) metrics(next http.Handler) http.Handler {
-	// Initialize the new expvar variables when the middleware chain is first built.
-	var (
-		totalRequestsReceived           = expvar.NewInt("total_requests_received")
-		totalResponsesSent              = expvar.NewInt("total_responses_sent")
-		totalProcessingTimeMicroseconds = expvar.NewInt("total_processing_time_μs")
-	)
-
-	// The following code will be run for every request...
+
+// metrics counts requests and times them by method, route pattern and status, and
+// keeps track of how many are in flight. The metrics themselves live in app.metricSet,
+// so building the chain twice doesn't register them twice.
+func (app *application) metrics(router *httprouter.Router, next http.Handler) http.Handler {
+	m := app.metricSet
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Record the time that we started to process the request.
 		start := time.Now()
 
-		// Use the Add() method to increment the number of requests received by 1
-		totalRequestsReceived.Add(1)
+		m.requestsInFlight.Inc()
+		defer m.requestsInFlight.Dec()
 
-		// Call the next handler in the chain
-		next.ServeHTTP(w, r)
+		rr := newResponseRecorder(w)
+
+		next.ServeHTTP(rr, r)
 
-		// On the way back up the middleware chain, increment the number of responses
-		// sent by 1.
-		totalResponsesSent.Add(1)
+		method := metricsMethod(r.Method)
+		route := routePattern(router, r)
+		status := strconv.Itoa(rr.status)
 
-		// Calculate the number of microseconds since we began to process the request,
-		// then increment the total processing time by this amount.
-		duration := time.Since(start).Microseconds()
-		totalProcessingTimeMicroseconds.Add(duration)
+		m.requests.Inc(method, route, status)
+		m.requestDuration.Observe(time.Since(start).Seconds(), method, route, status)
 	})
 }
-
-// To do: Recording HTTP Status Codes Metrics
-
-// in all cases, using the expvar package to collect and publish
-// the metrics gives you a great platform from which you can integrate
-// any external monitoring, alerting or visualization tools.
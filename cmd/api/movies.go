@@ -50,12 +50,15 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	user := app.contextGetUser(r)
+
 	// Copy the values from the input struct to a new Movie struct.
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: data.Runtime(input.Runtime), // input.Runtime: custom json decoding
-		Genres:  input.Genres,
+		Title:     input.Title,
+		Year:      input.Year,
+		Runtime:   data.Runtime(input.Runtime), // input.Runtime: custom json decoding
+		Genres:    input.Genres,
+		CreatedBy: &user.ID,
 	}
 
 	// Initialize a new Validator.
@@ -127,6 +130,11 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Only the movie's owner, its collaborators and movie admins can change it.
+	if !app.requireMovieEditor(w, r, movie) {
+		return
+	}
+
 	// advanced crud operations
 	var input struct {
 		Title   *string       `json:"title"`
@@ -193,7 +201,22 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.requireMovieEditor(w, r, movie) {
+		return
+	}
+
+	err = app.models.Movies.Delete(movie.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -242,6 +265,7 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	var input struct {
 		Title  string
 		Genres []string
+		Mine   bool
 		data.Filters
 	}
 
@@ -257,6 +281,9 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	input.Title = app.readString(qs, "title", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
 
+	// With mine=true only the movies the user created or collaborates on are listed.
+	input.Mine = app.readBool(qs, "mine", false, v)
+
 	// Get the page and page_size query string values as integers. Notice that we set
 	// the default page value to 1 and default page_size to 20, and that we pass the
 	// validator instance as the final argument here.
@@ -289,7 +316,18 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 
 	// Call the GetAll() method to retrieve the movies, passing in the various filter
 	// parameters.
-	movies, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	var userID int64
+	if input.Mine {
+		// Anonymous users can list movies if the deployment allows it, but have none.
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+		userID = user.ID
+	}
+
+	movies, err := app.models.Movies.GetAll(input.Title, input.Genres, userID, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
+)
+
+// The token in security notifications lasts long enough for users who only check their
+// email now and then.
+const secureAccountTTL = 7 * 24 * time.Hour
+
+// notify emails the user about a change to their account, unless it's a notification
+// they have turned off. The email says when it happened and where the request came
+// from, and carries a token which logs out all of their sessions if it wasn't them.
+// It's sent in the background, and failures are only logged.
+func (app *application) notify(r *http.Request, userID int64, kind string) {
+	ip := "unknown"
+	if clientIP := app.clientIP(r); clientIP != nil {
+		ip = clientIP.String()
+	}
+
+	userAgent := r.UserAgent()
+	now := time.Now()
+
+	app.background(r, func() {
+		err := app.sendNotification(r, userID, kind, now, ip, userAgent)
+		if err != nil {
+			app.requestLogger(r).PrintError(err, map[string]string{
+				"user_id":      strconv.FormatInt(userID, 10),
+				"notification": kind,
+			})
+		}
+	})
+}
+
+// notifyLogin records where the user logged in from, and sends them a new_login
+// notification if it's an IP address or a device they haven't used before.
+func (app *application) notifyLogin(r *http.Request, user *data.User) {
+	ip := ""
+	if clientIP := app.clientIP(r); clientIP != nil {
+		ip = clientIP.String()
+	}
+
+	newIP, newDevice, err := app.models.Notifications.RecordLogin(user.ID, ip, r.UserAgent())
+	if err != nil {
+		app.requestLogger(r).PrintError(err, map[string]string{"user_id": strconv.FormatInt(user.ID, 10)})
+		return
+	}
+
+	if newIP || newDevice {
+		app.notify(r, user.ID, data.NotificationNewLogin)
+	}
+}
+
+func (app *application) sendNotification(r *http.Request, userID int64, kind string, at time.Time, ip, userAgent string) error {
+	optional := slices.Contains(data.OptionalNotifications, kind)
+
+	if optional {
+		optedOut, err := app.models.Notifications.HasOptedOut(userID, kind)
+		if err != nil {
+			return err
+		}
+		if optedOut {
+			return nil
+		}
+	}
+
+	user, err := app.models.Users.Get(userID)
+	if err != nil {
+		return err
+	}
+
+	token, err := app.models.Tokens.New(userID, secureAccountTTL, data.ScopeSecureAccount)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]any{
+		"time":               at.UTC().Format(time.RFC1123),
+		"ip":                 ip,
+		"userAgent":          userAgent,
+		"secureAccountToken": token.Plaintext,
+		"optional":           optional,
+	}
+
+	return app.requestMailer(r).Send(user.Email, kind+".tmpl", data)
+}
+
+// The secureAccountHandler is where the "this wasn't me" token in a security
+// notification is sent. It logs the user out everywhere: their authentication tokens,
+// JWTs, OAuth access tokens and API keys are all revoked. They still need to reset
+// their password if someone else knows it.
+func (app *application) secureAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeSecureAccount, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.logOutEverywhere(r, user.ID, "secure_account")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeSecureAccount, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"message": "you have been logged out everywhere; please reset your password if someone else may know it"}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showNotificationSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	optOuts, err := app.models.Notifications.GetOptOuts(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeNotificationSettings(w, r, optOuts)
+}
+
+// The updateNotificationSettingsHandler replaces the list of notifications the user
+// has turned off. Only those in data.OptionalNotifications can be.
+func (app *application) updateNotificationSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	if app.isDelegatedCredential(r) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		OptOut []string `json:"opt_out"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateNotificationOptOuts(v, input.OptOut); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Notifications.SetOptOuts(app.contextGetUser(r).ID, input.OptOut)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeNotificationSettings(w, r, input.OptOut)
+}
+
+func (app *application) writeNotificationSettings(w http.ResponseWriter, r *http.Request, optOuts []string) {
+	env := envelope{
+		"notifications": envelope{
+			"opt_out":  optOuts,
+			"optional": data.OptionalNotifications,
+		},
+	}
+
+	err := app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,521 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
+)
+
+func (app *application) registerOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	if app.isDelegatedCredential(r) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		GrantTypes   []string `json:"grant_types"`
+		Confidential bool     `json:"confidential"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	if input.RedirectURIs == nil {
+		input.RedirectURIs = []string{}
+	}
+
+	client := &data.OAuthClient{
+		UserID:       user.ID,
+		Name:         input.Name,
+		RedirectURIs: input.RedirectURIs,
+		Scopes:       input.Scopes,
+		GrantTypes:   input.GrantTypes,
+	}
+
+	err = client.GenerateCredentials(input.Confidential)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateOAuthClient(v, client); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// A client can only ever be granted permissions which its owner has, because
+	// client credentials tokens act on the owner's behalf.
+	permissions, err := app.permissionsForRequest(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, scope := range client.Scopes {
+		if !permissions.Include(scope) {
+			v.AddError("scopes", fmt.Sprintf("must only contain permissions you have (%q is not one of them)", scope))
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.OAuthClients.Insert(client)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"client": client}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listOAuthClientsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	clients, err := app.models.OAuthClients.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"clients": clients}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.OAuthClients.DeleteForUser(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "client successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// authorizationRequest holds the parameters of an authorization code request (RFC 6749
+// section 4.1.1, plus the PKCE parameters from RFC 7636). The consent decision is only
+// present when the user approves or denies the request.
+type authorizationRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+	Approve             bool   `json:"approve"`
+}
+
+// checkAuthorizationRequest validates an authorization request for the request user,
+// returning the client and the scopes which would be granted.
+func (app *application) checkAuthorizationRequest(r *http.Request, v *validator.Validator, input *authorizationRequest) (*data.OAuthClient, []string, error) {
+	v.Check(input.ResponseType == "code", "response_type", "must be code")
+	v.Check(input.ClientID != "", "client_id", "must be provided")
+	v.Check(input.CodeChallenge != "", "code_challenge", "must be provided")
+	v.Check(len(input.CodeChallenge) == 43, "code_challenge", "must be a base64url-encoded SHA-256 hash")
+	v.Check(input.CodeChallengeMethod == "S256", "code_challenge_method", "must be S256")
+
+	if !v.Valid() {
+		return nil, nil, nil
+	}
+
+	client, err := app.models.OAuthClients.GetByClientID(input.ClientID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("client_id", "unknown client")
+			return nil, nil, nil
+		default:
+			return nil, nil, err
+		}
+	}
+
+	v.Check(client.AllowsGrant(data.GrantAuthorizationCode), "client_id", "client is not allowed to use the authorization_code grant")
+	v.Check(client.AllowsRedirectURI(input.RedirectURI), "redirect_uri", "must exactly match a redirect URI registered for the client")
+
+	scopes := strings.Fields(input.Scope)
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+
+	permissions, err := app.permissionsForRequest(r, app.contextGetUser(r))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, scope := range scopes {
+		if !validator.PermittedValue(scope, client.Scopes...) {
+			v.AddError("scope", fmt.Sprintf("%q is not registered for the client", scope))
+		} else if !permissions.Include(scope) {
+			v.AddError("scope", fmt.Sprintf("you don't have the %q permission", scope))
+		}
+	}
+
+	return client, scopes, nil
+}
+
+// The showAuthorizationRequestHandler is the first half of the consent step. It checks
+// an authorization request and tells the front end what the user is being asked to
+// grant, and whether they have already consented to it.
+func (app *application) showAuthorizationRequestHandler(w http.ResponseWriter, r *http.Request) {
+	if app.isDelegatedCredential(r) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	qs := r.URL.Query()
+
+	input := &authorizationRequest{
+		ResponseType:        app.readString(qs, "response_type", ""),
+		ClientID:            app.readString(qs, "client_id", ""),
+		RedirectURI:         app.readString(qs, "redirect_uri", ""),
+		Scope:               app.readString(qs, "scope", ""),
+		State:               app.readString(qs, "state", ""),
+		CodeChallenge:       app.readString(qs, "code_challenge", ""),
+		CodeChallengeMethod: app.readString(qs, "code_challenge_method", ""),
+	}
+
+	v := validator.New()
+
+	client, scopes, err := app.checkAuthorizationRequest(r, v, input)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	consented, err := app.models.OAuthConsents.Get(app.contextGetUser(r).ID, client.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"client": map[string]string{
+			"client_id": client.ClientID,
+			"name":      client.Name,
+		},
+		"scopes":           scopes,
+		"consent_required": len(data.Permissions(consented).Intersect(scopes)) != len(scopes),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The authorizeHandler records the user's consent decision. Either way it responds
+// with the URI the user agent should be redirected to, carrying an authorization code
+// or an access_denied error back to the client.
+func (app *application) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	if app.isDelegatedCredential(r) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input authorizationRequest
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	client, scopes, err := app.checkAuthorizationRequest(r, v, &input)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	redirect, err := url.Parse(input.RedirectURI)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	params := redirect.Query()
+
+	if input.State != "" {
+		params.Set("state", input.State)
+	}
+
+	if !input.Approve {
+		params.Set("error", "access_denied")
+	} else {
+		err = app.models.OAuthConsents.Grant(user.ID, client.ID, scopes)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		code := &data.OAuthCode{
+			ClientID:      client.ID,
+			UserID:        user.ID,
+			RedirectURI:   input.RedirectURI,
+			Scopes:        scopes,
+			CodeChallenge: input.CodeChallenge,
+		}
+
+		err = app.models.OAuthCodes.New(code, app.config.oauth.codeTTL)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		params.Set("code", code.Plaintext)
+	}
+
+	redirect.RawQuery = params.Encode()
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"redirect_to": redirect.String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// authenticateOAuthClient identifies the client making a token request, using either
+// HTTP Basic authentication or the client_id and client_secret form parameters. For
+// public clients no secret is expected, and authenticated is false.
+func (app *application) authenticateOAuthClient(r *http.Request) (client *data.OAuthClient, authenticated bool, err error) {
+	clientID, secret, hasBasic := r.BasicAuth()
+	if !hasBasic {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	if clientID == "" {
+		return nil, false, data.ErrRecordNotFound
+	}
+
+	client, err = app.models.OAuthClients.GetByClientID(clientID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if client.IsConfidential() {
+		if !client.SecretMatches(secret) {
+			return nil, false, data.ErrRecordNotFound
+		}
+		return client, true, nil
+	}
+
+	if secret != "" {
+		return nil, false, data.ErrRecordNotFound
+	}
+
+	return client, false, nil
+}
+
+// oauthClientEndpoints are the endpoints which OAuth clients call with their own
+// credentials rather than a bearer token.
+var oauthClientEndpoints = map[string]bool{
+	"/v1/oauth/token":      true,
+	"/v1/oauth/introspect": true,
+	"/v1/oauth/revoke":     true,
+}
+
+// The oauthTokenHandler is the token endpoint. Unlike the rest of the API it takes a
+// form-encoded body and responds in the format required by RFC 6749, because that is
+// what OAuth client libraries speak.
+func (app *application) oauthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+
+	err := r.ParseForm()
+	if err != nil {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "the request body could not be parsed")
+		return
+	}
+
+	client, authenticated, err := app.authenticateOAuthClient(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.oauthErrorResponse(w, r, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	grantType := r.PostForm.Get("grant_type")
+
+	if !validator.PermittedValue(grantType, data.GrantAuthorizationCode, data.GrantClientCredentials) {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be authorization_code or client_credentials")
+		return
+	}
+
+	if !client.AllowsGrant(grantType) {
+		app.oauthErrorResponse(w, r, http.StatusBadRequest, "unauthorized_client", "the client is not allowed to use this grant type")
+		return
+	}
+
+	var userID int64
+	var scopes []string
+
+	switch grantType {
+	case data.GrantAuthorizationCode:
+		code, err := app.models.OAuthCodes.Consume(r.PostForm.Get("code"))
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "invalid or expired authorization code")
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if code.ClientID != client.ID || code.RedirectURI != r.PostForm.Get("redirect_uri") {
+			app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "the authorization code was not issued to this client and redirect URI")
+			return
+		}
+
+		if !pkceVerifierMatches(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+			app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_grant", "code_verifier does not match the code challenge")
+			return
+		}
+
+		userID = code.UserID
+		scopes = code.Scopes
+
+	case data.GrantClientCredentials:
+		if !authenticated {
+			app.oauthErrorResponse(w, r, http.StatusUnauthorized, "invalid_client", "the client_credentials grant requires client authentication")
+			return
+		}
+
+		scopes = strings.Fields(r.PostForm.Get("scope"))
+		if len(scopes) == 0 {
+			scopes = client.Scopes
+		}
+
+		for _, scope := range scopes {
+			if !validator.PermittedValue(scope, client.Scopes...) {
+				app.oauthErrorResponse(w, r, http.StatusBadRequest, "invalid_scope", fmt.Sprintf("%q is not registered for the client", scope))
+				return
+			}
+		}
+
+		// Client credentials tokens act on behalf of the user who registered the
+		// client, so they are also limited by that user's permissions.
+		userID = client.UserID
+	}
+
+	token, err := app.models.Tokens.NewForClient(userID, client.ID, app.config.oauth.tokenTTL, scopes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, data.AuditTokenCreated, userID, map[string]string{
+		"scope":           strings.Join(scopes, " "),
+		"oauth_client_id": strconv.FormatInt(client.ID, 10),
+	})
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+
+	env := envelope{
+		"access_token": token.Plaintext,
+		"token_type":   "Bearer",
+		"expires_in":   int(app.config.oauth.tokenTTL.Seconds()),
+		"scope":        strings.Join(scopes, " "),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// pkceVerifierMatches checks a PKCE code verifier against an S256 code challenge
+// (RFC 7636 section 4.6).
+func pkceVerifierMatches(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:]) == challenge
+}
+
+// The authorizationServerMetadataHandler serves the RFC 8414 metadata document, which
+// lets OAuth client libraries discover our endpoints and capabilities.
+func (app *application) authorizationServerMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	scopes, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	issuer := strings.TrimSuffix(app.config.oauth.issuer, "/")
+
+	env := envelope{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/v1/oauth/authorize",
+		"token_endpoint":                        issuer + "/v1/oauth/token",
+		"introspection_endpoint":                issuer + "/v1/oauth/introspect",
+		"revocation_endpoint":                   issuer + "/v1/oauth/revoke",
+		"scopes_supported":                      scopes,
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{data.GrantAuthorizationCode, data.GrantClientCredentials},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
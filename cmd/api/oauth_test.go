@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+)
+
+// newTestClient registers a confidential client for the user.
+func newTestClient(t *testing.T, app *application, user *data.User, scopes ...string) *data.OAuthClient {
+	t.Helper()
+
+	client := &data.OAuthClient{
+		UserID:       user.ID,
+		Name:         "Test Client",
+		RedirectURIs: []string{"https://client.example.com/callback"},
+		Scopes:       scopes,
+		GrantTypes:   []string{data.GrantAuthorizationCode, data.GrantClientCredentials},
+	}
+
+	err := client.GenerateCredentials(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.OAuthClients.Insert(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+func newFormRequest(target string, form url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+// Clients send their credentials to the token, introspection and revocation endpoints
+// with HTTP Basic, which authenticate() has to leave alone rather than reject as a
+// malformed bearer token.
+func TestAuthenticateLeavesBasicToClientEndpoints(t *testing.T) {
+	app := newTestApplication(t, nil)
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{"token endpoint", "/v1/oauth/token", http.StatusOK},
+		{"introspection endpoint", "/v1/oauth/introspect", http.StatusOK},
+		{"revocation endpoint", "/v1/oauth/revoke", http.StatusOK},
+		{"other endpoint", "/v1/movies", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if user := app.contextGetUser(r); !user.IsAnonymous() {
+					t.Errorf("got user %d; want the anonymous user", user.ID)
+				}
+				if id, secret, ok := r.BasicAuth(); !ok || id != "glc_client" || secret != "s3cret" {
+					t.Errorf("got Basic credentials %q, %q, %t", id, secret, ok)
+				}
+			})
+
+			r := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			r.SetBasicAuth("glc_client", "s3cret")
+
+			rr := httptest.NewRecorder()
+			app.authenticate(next).ServeHTTP(rr, r)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("got status %d; want %d", rr.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestOAuthTokenClientCredentials(t *testing.T) {
+	app := newTestApplication(t, newTestDB(t))
+	h := app.routes()
+
+	owner := newTestUser(t, app, "owner@example.com", "movies:read")
+	client := newTestClient(t, app, owner, "movies:read")
+
+	tests := []struct {
+		name       string
+		basic      bool
+		secret     string
+		scope      string
+		wantStatus int
+		wantError  string
+	}{
+		{"client_secret_basic", true, client.Secret, "movies:read", http.StatusOK, ""},
+		{"client_secret_post", false, client.Secret, "movies:read", http.StatusOK, ""},
+		{"default scopes", true, client.Secret, "", http.StatusOK, ""},
+		{"wrong secret with Basic", true, "wrong", "movies:read", http.StatusUnauthorized, "invalid_client"},
+		{"wrong secret in the body", false, "wrong", "movies:read", http.StatusUnauthorized, "invalid_client"},
+		{"unregistered scope", true, client.Secret, "movies:write", http.StatusBadRequest, "invalid_scope"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"grant_type": {data.GrantClientCredentials}}
+			if tt.scope != "" {
+				form.Set("scope", tt.scope)
+			}
+			if !tt.basic {
+				form.Set("client_id", client.ClientID)
+				form.Set("client_secret", tt.secret)
+			}
+
+			r := newFormRequest("/v1/oauth/token", form)
+			if tt.basic {
+				r.SetBasicAuth(client.ClientID, tt.secret)
+			}
+
+			res, body := do(t, h, r)
+
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d; want %d (%v)", res.StatusCode, tt.wantStatus, body)
+			}
+			if tt.wantError != "" {
+				if body["error"] != tt.wantError {
+					t.Errorf("got error %v; want %q", body["error"], tt.wantError)
+				}
+				return
+			}
+
+			token, _ := body["access_token"].(string)
+			if token == "" {
+				t.Fatalf("got no access token in %v", body)
+			}
+
+			// The token can be used for what it was granted, and nothing more.
+			res, _ = do(t, h, withBearer(httptest.NewRequest(http.MethodGet, "/v1/movies", nil), token))
+			if res.StatusCode != http.StatusOK {
+				t.Errorf("GET /v1/movies: got status %d; want %d", res.StatusCode, http.StatusOK)
+			}
+
+			res, _ = do(t, h, withBearer(httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(`{}`)), token))
+			if res.StatusCode != http.StatusForbidden {
+				t.Errorf("POST /v1/movies: got status %d; want %d", res.StatusCode, http.StatusForbidden)
+			}
+		})
+	}
+}
+
+func TestPKCEVerifierMatches(t *testing.T) {
+	verifier := strings.Repeat("v", 43)
+	hash := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(hash[:])
+
+	tests := []struct {
+		name     string
+		verifier string
+		want     bool
+	}{
+		{"matching verifier", verifier, true},
+		{"other verifier", strings.Repeat("w", 43), false},
+		{"too short", verifier[:42], false},
+		{"too long", strings.Repeat("v", 129), false},
+		{"challenge as verifier", challenge, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pkceVerifierMatches(tt.verifier, challenge); got != tt.want {
+				t.Errorf("got %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOAuthAuthorizationCode(t *testing.T) {
+	app := newTestApplication(t, newTestDB(t))
+	h := app.routes()
+
+	owner := newTestUser(t, app, "owner@example.com", "movies:read")
+	client := newTestClient(t, app, owner, "movies:read", "movies:write")
+
+	user := newTestUser(t, app, "alice@example.com", "movies:read")
+	userToken := newTestToken(t, app, user)
+
+	verifier := strings.Repeat("v", 43)
+	hash := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(hash[:])
+
+	redirectURI := client.RedirectURIs[0]
+
+	// authorize asks for the user's consent to scope, and returns the redirect the
+	// client would get.
+	authorize := func(t *testing.T, scope string, approve bool) (int, url.Values) {
+		t.Helper()
+
+		body := `{"response_type": "code", "client_id": "` + client.ClientID + `", "redirect_uri": "` + redirectURI + `", "scope": "` + scope + `", "state": "xyz", "code_challenge": "` + challenge + `", "code_challenge_method": "S256", "approve": ` + strconv.FormatBool(approve) + `}`
+
+		res, env := do(t, h, withBearer(httptest.NewRequest(http.MethodPost, "/v1/oauth/authorize", strings.NewReader(body)), userToken))
+		if res.StatusCode != http.StatusOK {
+			return res.StatusCode, nil
+		}
+
+		to, _ := env["redirect_to"].(string)
+
+		redirect, err := url.Parse(to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(to, redirectURI) {
+			t.Errorf("got redirect to %q; want it to start with %q", to, redirectURI)
+		}
+
+		return res.StatusCode, redirect.Query()
+	}
+
+	exchange := func(code, redirectURI, verifier string) (int, map[string]any) {
+		r := newFormRequest("/v1/oauth/token", url.Values{
+			"grant_type":    {data.GrantAuthorizationCode},
+			"code":          {code},
+			"redirect_uri":  {redirectURI},
+			"code_verifier": {verifier},
+		})
+		r.SetBasicAuth(client.ClientID, client.Secret)
+
+		res, body := do(t, h, r)
+		return res.StatusCode, body
+	}
+
+	t.Run("denied", func(t *testing.T) {
+		status, params := authorize(t, "movies:read", false)
+		if status != http.StatusOK || params.Get("error") != "access_denied" || params.Get("code") != "" || params.Get("state") != "xyz" {
+			t.Errorf("got %d %v", status, params)
+		}
+	})
+
+	t.Run("scope the user doesn't have", func(t *testing.T) {
+		status, _ := authorize(t, "movies:write", true)
+		if status != http.StatusUnprocessableEntity {
+			t.Errorf("got status %d; want %d", status, http.StatusUnprocessableEntity)
+		}
+	})
+
+	tests := []struct {
+		name        string
+		redirectURI string
+		verifier    string
+	}{
+		{"wrong code_verifier", redirectURI, strings.Repeat("w", 43)},
+		{"wrong redirect_uri", "https://client.example.com/other", verifier},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, params := authorize(t, "movies:read", true)
+
+			status, body := exchange(params.Get("code"), tt.redirectURI, tt.verifier)
+			if status != http.StatusBadRequest || body["error"] != "invalid_grant" {
+				t.Errorf("got %d %v; want %d invalid_grant", status, body["error"], http.StatusBadRequest)
+			}
+		})
+	}
+
+	t.Run("approved", func(t *testing.T) {
+		status, params := authorize(t, "movies:read", true)
+		if status != http.StatusOK || params.Get("code") == "" || params.Get("state") != "xyz" {
+			t.Fatalf("got %d %v", status, params)
+		}
+
+		status, body := exchange(params.Get("code"), redirectURI, verifier)
+		if status != http.StatusOK {
+			t.Fatalf("got status %d (%v)", status, body)
+		}
+
+		token, _ := body["access_token"].(string)
+
+		res, _ := do(t, h, withBearer(httptest.NewRequest(http.MethodGet, "/v1/movies", nil), token))
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("GET /v1/movies: got status %d; want %d", res.StatusCode, http.StatusOK)
+		}
+
+		// Codes can only be used once.
+		status, body = exchange(params.Get("code"), redirectURI, verifier)
+		if status != http.StatusBadRequest || body["error"] != "invalid_grant" {
+			t.Errorf("replaying the code: got %d %v; want %d invalid_grant", status, body["error"], http.StatusBadRequest)
+		}
+	})
+}
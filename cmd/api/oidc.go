@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/oidc"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
+)
+
+var errUnverifiedEmail = errors.New("email address not verified by identity provider")
+
+// oidcStateCookie holds the state of the login the browser started, so that the
+// callback can check the login it's finishing was started by the same browser.
+// Without it, an attacker could start a login of their own and send the victim the
+// callback URL, logging them in to the attacker's account.
+const oidcStateCookie = "greenlight_oidc_state"
+
+// The oidcLoginHandler starts a single sign-on login. It stores the state, nonce and
+// PKCE verifier for the login, sets the state cookie and returns the provider URL the
+// client should send the user to.
+func (app *application) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	login, err := app.models.OIDCLogins.New(10 * time.Minute)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	authorizationURL, err := app.oidc.AuthCodeURL(r.Context(), login.State, login.Nonce, login.CodeVerifier)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.setOIDCStateCookie(w, login.State, login.Expiry)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"authorization_url": authorizationURL}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The oidcCallbackHandler is where the provider sends the user back to. It exchanges
+// the code for an ID token, validates it, finds or provisions the matching user and
+// logs them in like any other login, so users with two-factor authentication still
+// have to give a code.
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	qs := r.URL.Query()
+
+	if providerError := qs.Get("error"); providerError != "" {
+		app.badRequestResponse(w, r, errors.New("the identity provider returned an error: "+providerError))
+		return
+	}
+
+	v := validator.New()
+
+	code := app.readString(qs, "code", "")
+	state := app.readString(qs, "state", "")
+
+	v.Check(code != "", "code", "must be provided")
+	v.Check(state != "", "state", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The cookie is only needed once, whatever happens next.
+	cookie, err := r.Cookie(oidcStateCookie)
+	app.setOIDCStateCookie(w, "", time.Time{})
+
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		v.AddError("state", "does not match the login started by this browser")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	login, err := app.models.OIDCLogins.Consume(state)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("state", "invalid or expired login state")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	rawIDToken, err := app.oidc.Exchange(r.Context(), code, login.CodeVerifier)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrExchangeFailed):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	claims, err := app.oidc.VerifyIDToken(r.Context(), rawIDToken, login.Nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrInvalidIDToken):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.userForIdentity(claims)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnverifiedEmail):
+			v.AddError("email", "must be verified by the identity provider")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, errRegistrationClosed):
+			app.registrationClosedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+// setOIDCStateCookie sets the state cookie, or clears it if state is empty. It's only
+// sent to the callback, and SameSite=Lax still lets the browser send it when the
+// provider redirects back.
+func (app *application) setOIDCStateCookie(w http.ResponseWriter, state string, expiry time.Time) {
+	cookie := &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/v1/oidc/callback",
+		Expires:  expiry,
+		HttpOnly: true,
+		Secure:   app.config.env != "development",
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	if state == "" {
+		cookie.MaxAge = -1
+	}
+
+	http.SetCookie(w, cookie)
+}
+
+// userForIdentity returns the user for an external identity. Identities are linked to
+// existing users only when the provider has verified the email address, otherwise
+// anyone could take over an account by registering its address with the provider.
+// Users seen for the first time are provisioned, activated and given the default
+// permissions.
+func (app *application) userForIdentity(claims *oidc.Claims) (*data.User, error) {
+	user, err := app.models.Identities.GetUser(claims.Issuer, claims.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if !claims.EmailVerified {
+		return nil, errUnverifiedEmail
+	}
+
+	user, err = app.models.Users.GetByEmail(claims.Email)
+	switch {
+	case err == nil:
+		// The provider has verified the address, which is all account activation would
+		// have proved.
+		if !user.Activated {
+			user.Activated = true
+
+			err = app.models.Users.Update(user)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+	case errors.Is(err, data.ErrRecordNotFound):
+		user, err = app.provisionUser(claims)
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, err
+	}
+
+	identity := &data.Identity{
+		UserID:  user.ID,
+		Issuer:  claims.Issuer,
+		Subject: claims.Subject,
+		Email:   claims.Email,
+	}
+
+	err = app.models.Identities.Insert(identity)
+	if err != nil {
+		// Two callbacks for the same identity raced each other, and the other one won.
+		if errors.Is(err, data.ErrDuplicateIdentity) {
+			return app.models.Identities.GetUser(claims.Issuer, claims.Subject)
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (app *application) provisionUser(claims *oidc.Claims) (*data.User, error) {
+	// Single sign-on can't carry an invitation, so in invitation mode only existing
+	// users can use it.
+	v := validator.New()
+	if app.checkRegistration(v, claims.Email); !v.Valid() {
+		return nil, errRegistrationClosed
+	}
+
+	name := claims.Name
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	user := &data.User{
+		Name:      name,
+		Email:     claims.Email,
+		Activated: true,
+	}
+
+	// Single sign-on users don't have a password of their own, so set one nobody knows.
+	err := user.Password.SetRandom()
+	if err != nil {
+		return nil, err
+	}
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		return nil, errors.New("identity provider returned an invalid name or email address")
+	}
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		return nil, err
+	}
+
+	// Single sign-on users are activated straight away, so they get the activation
+	// permissions along with the ones registerUserHandler gives.
+	codes := data.DefaultPermissions.Registration.Union(data.DefaultPermissions.Activation)
+
+	err = app.models.Permissions.AddForUser(user.ID, codes...)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
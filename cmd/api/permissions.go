@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (app *application) listPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	permissions, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listPermissionUsersHandler(w http.ResponseWriter, r *http.Request) {
+	code := httprouter.ParamsFromContext(r.Context()).ByName("code")
+
+	permissions, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !slices.Contains(permissions, code) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	users, err := app.models.Users.GetAllForPermission(code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The showUserPermissionsHandler is used both by admins, for any user, and by users
+// for themselves through /v1/users/me/permissions.
+func (app *application) showUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := app.contextGetUser(r).ID
+
+	if httprouter.ParamsFromContext(r.Context()).ByName("id") != "me" {
+		user, err := app.userForIDParam(w, r)
+		if err != nil {
+			return
+		}
+		userID = user.ID
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) grantUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeUserPermissions(w, r, data.PermissionGrant)
+}
+
+func (app *application) revokeUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeUserPermissions(w, r, data.PermissionRevoke)
+}
+
+// changeUserPermissions grants or revokes the permissions listed in the request body,
+// records the change and responds with the user's permissions afterwards. Both are
+// idempotent: granting a permission the user has, or revoking one they don't, is fine.
+func (app *application) changeUserPermissions(w http.ResponseWriter, r *http.Request, action string) {
+	user, err := app.userForIDParam(w, r)
+	if err != nil {
+		return
+	}
+
+	var input struct {
+		Permissions []string `json:"permissions"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	all, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	actor := app.contextGetUser(r)
+
+	v := validator.New()
+
+	v.Check(len(input.Permissions) > 0, "permissions", "must contain at least 1 permission")
+	v.Check(validator.Unique(input.Permissions), "permissions", "must not contain duplicate values")
+
+	validatePermissionCodes(v, all, input.Permissions)
+
+	// Stop admins from locking themselves (and possibly everyone) out of this API.
+	if action == data.PermissionRevoke && user.ID == actor.ID && data.Permissions(input.Permissions).Include("permissions:admin") {
+		v.AddError("permissions", "you can't revoke your own permissions:admin permission")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	change := &data.PermissionChange{
+		ActorID: actor.ID,
+		UserID:  user.ID,
+		Action:  action,
+		Codes:   input.Permissions,
+	}
+
+	err = app.models.PermissionChanges.Apply(change)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	auditAction := data.AuditPermissionGranted
+	if action == data.PermissionRevoke {
+		auditAction = data.AuditPermissionRevoked
+	}
+	app.audit(r, auditAction, user.ID, map[string]string{"permissions": strings.Join(input.Permissions, ",")})
+
+	app.requestLogger(r).PrintInfo("permissions changed", map[string]string{
+		"action":      action,
+		"actor_id":    strconv.FormatInt(actor.ID, 10),
+		"user_id":     strconv.FormatInt(user.ID, 10),
+		"permissions": strings.Join(input.Permissions, ","),
+	})
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// validatePermissionCodes checks that every code exists. It doesn't use Include, as
+// that would let "movies:*" vouch for any code starting with "movies:".
+func validatePermissionCodes(v *validator.Validator, all data.Permissions, codes []string) {
+	for _, code := range codes {
+		if !slices.Contains(all, code) {
+			v.AddError("permissions", fmt.Sprintf("%q is not a permission", code))
+		}
+	}
+}
+
+// userForIDParam fetches the user named by the :id parameter. It sends the error
+// response itself, so callers only need to return when err isn't nil.
+func (app *application) userForIDParam(w http.ResponseWriter, r *http.Request) (*data.User, error) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, err
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// permissionList splits a comma-separated list of permission codes, as given to the
+// -anonymous-permissions, -registration-permissions and -activation-permissions flags.
+func permissionList(s string) data.Permissions {
+	permissions := data.Permissions{}
+	for _, code := range strings.Split(s, ",") {
+		if code = strings.TrimSpace(code); code != "" {
+			permissions = append(permissions, code)
+		}
+	}
+	return permissions
+}
+
+// checkDefaultPermissions checks that every permission in data.DefaultPermissions
+// exists, or for a wildcard such as "users:*", that it includes a permission which does.
+// Anonymous requests have no user behind them, so they're limited to reading: handlers
+// which write record who made the change.
+func (app *application) checkDefaultPermissions() error {
+	all, err := app.models.Permissions.GetAll()
+	if err != nil {
+		return err
+	}
+
+	defaults := data.DefaultPermissions
+	for _, code := range defaults.Anonymous.Union(defaults.Registration).Union(defaults.Activation) {
+		if !slices.Contains(all, code) && len(data.Permissions{code}.Intersect(all)) == 0 {
+			return fmt.Errorf("default permission %q doesn't exist", code)
+		}
+	}
+
+	for _, code := range all {
+		if defaults.Anonymous.Include(code) && !strings.HasSuffix(code, ":read") {
+			return fmt.Errorf("anonymous permission %q isn't read-only", code)
+		}
+	}
+
+	return nil
+}
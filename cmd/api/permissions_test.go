@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestChangeUserPermissions(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db)
+	h := app.routes()
+
+	admin := newTestUser(t, app, "admin@example.com", "permissions:admin")
+	adminToken := newTestToken(t, app, admin)
+
+	user := newTestUser(t, app, "alice@example.com", "movies:read")
+	target := "/v1/users/" + strconv.FormatInt(user.ID, 10) + "/permissions"
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+		want       []string
+	}{
+		{"grant", http.MethodPut, target, `{"permissions": ["movies:write"]}`, http.StatusOK, []string{"movies:read", "movies:write"}},
+		{"grant again", http.MethodPut, target, `{"permissions": ["movies:write"]}`, http.StatusOK, []string{"movies:read", "movies:write"}},
+		{"revoke", http.MethodDelete, target, `{"permissions": ["movies:read"]}`, http.StatusOK, []string{"movies:write"}},
+		{"unknown permission", http.MethodPut, target, `{"permissions": ["movies:fly"]}`, http.StatusUnprocessableEntity, nil},
+		{"own permissions:admin", http.MethodDelete, "/v1/users/" + strconv.FormatInt(admin.ID, 10) + "/permissions", `{"permissions": ["permissions:admin"]}`, http.StatusUnprocessableEntity, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := withBearer(httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)), adminToken)
+			res, body := do(t, h, r)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d; want %d (%v)", res.StatusCode, tt.wantStatus, body)
+			}
+
+			if tt.want == nil {
+				return
+			}
+
+			permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			slices.Sort(permissions)
+			if !slices.Equal(permissions, tt.want) {
+				t.Errorf("got permissions %v; want %v", permissions, tt.want)
+			}
+		})
+	}
+
+	// Every change which was made is recorded, and nothing else.
+	var changes int
+
+	err := db.QueryRow("SELECT count(*) FROM permission_changes WHERE user_id = $1", user.ID).Scan(&changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if changes != 3 {
+		t.Errorf("got %d permission changes recorded; want 3", changes)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Account deletion modes, set with -account-deletion-mode.
+const (
+	deletionAnonymise = "anonymise"
+	deletionDelete    = "delete"
+)
+
+// The createDataExportHandler starts building an archive of everything stored about
+// the user. It's built in the background, and the user is emailed a link to download
+// it when it's ready.
+func (app *application) createDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	if app.isDelegatedCredential(r) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(r, func() {
+		archive, err := app.buildDataExport(user)
+		if err != nil {
+			app.requestLogger(r).PrintError(err, map[string]string{"user_id": strconv.FormatInt(user.ID, 10)})
+			return
+		}
+
+		export, err := app.models.Exports.New(user.ID, archive, app.config.privacy.exportTTL)
+		if err != nil {
+			app.requestLogger(r).PrintError(err, map[string]string{"user_id": strconv.FormatInt(user.ID, 10)})
+			return
+		}
+
+		data := map[string]any{
+			"exportToken": export.Plaintext,
+			"expiry":      export.Expiry.Format(time.RFC1123),
+		}
+
+		err = app.requestMailer(r).Send(user.Email, "data_export.tmpl", data)
+		if err != nil {
+			app.requestLogger(r).PrintError(err, nil)
+		}
+	})
+
+	env := envelope{"message": "your data is being exported, and you will be emailed a link to download it when it's ready"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// buildDataExport collects the user's profile, the metadata of their tokens and API
+// keys, their permissions and roles, where they have logged in from, and the movies
+// they created, as indented JSON.
+func (app *application) buildDataExport(user *data.User) ([]byte, error) {
+	type exportedToken struct {
+		Scope       string    `json:"scope"`
+		Expiry      time.Time `json:"expiry"`
+		ClientID    *int64    `json:"oauth_client_id,omitempty"`
+		Permissions []string  `json:"permissions,omitempty"`
+	}
+
+	tokens, err := app.models.Tokens.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	exportedTokens := make([]exportedToken, len(tokens))
+	for i, token := range tokens {
+		exportedTokens[i] = exportedToken{
+			Scope:       token.Scope,
+			Expiry:      token.Expiry,
+			ClientID:    token.ClientID,
+			Permissions: token.Permissions,
+		}
+	}
+
+	apiKeys, err := app.models.APIKeys.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	logins, err := app.models.Notifications.GetAllLogins(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// GetAll also lists movies shared with the user, which they didn't create.
+	movies, err := app.models.Movies.GetAll("", []string{}, user.ID, data.Filters{})
+	if err != nil {
+		return nil, err
+	}
+
+	created := []*data.Movie{}
+	for _, movie := range movies {
+		if movie.CreatedBy != nil && *movie.CreatedBy == user.ID {
+			created = append(created, movie)
+		}
+	}
+
+	archive := envelope{
+		"exported_at": time.Now(),
+		"user":        user,
+		"tokens":      exportedTokens,
+		"api_keys":    apiKeys,
+		"permissions": permissions,
+		"roles":       roles,
+		"logins":      logins,
+		"movies":      created,
+	}
+
+	return json.MarshalIndent(archive, "", "\t")
+}
+
+// The downloadDataExportHandler serves an export. The token from the email is all that
+// is needed, so that the link works in a browser.
+func (app *application) downloadDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	export, err := app.models.Exports.GetForToken(token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	filename := fmt.Sprintf("greenlight-export-%s.json", export.CreatedAt.Format("2006-01-02"))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+	w.Write(export.Archive)
+}
+
+// The deleteCurrentUserHandler schedules the user's account for deletion once the
+// grace period has passed. The password is asked for again, so that a stolen session
+// can't be used to delete the account. Users who signed up through single sign-on
+// have no password they know, so a fresh magic link token will do instead. The user is
+// logged out everywhere (see logOutEverywhere()), and logging in again before the
+// deletion date cancels it.
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	if app.isDelegatedCredential(r) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Password == "" || input.TokenPlaintext == "", "password", "must not be provided together with token")
+
+	if input.TokenPlaintext != "" {
+		data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	} else {
+		data.ValidatePasswordAttempt(v, input.Password)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if input.TokenPlaintext != "" {
+		tokenUser, err := app.models.Users.GetForToken(data.ScopeMagicLink, input.TokenPlaintext)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if tokenUser == nil || tokenUser.ID != user.ID {
+			v.AddError("token", "invalid or expired magic link token")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeMagicLink, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	} else {
+		match, err := user.Password.Matches(input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !match {
+			v.AddError("password", "is incorrect; accounts without a password can send a token from POST /v1/tokens/magic-link instead")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	requestedAt, err := app.models.Users.RequestDeletion(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.logOutEverywhere(r, user.ID, "account_deletion")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	deletionDate := requestedAt.Add(app.config.privacy.deletionGrace)
+
+	app.background(r, func() {
+		data := map[string]any{
+			"deletionDate": deletionDate.Format(time.RFC1123),
+		}
+
+		err := app.requestMailer(r).Send(user.Email, "account_deletion.tmpl", data)
+		if err != nil {
+			app.requestLogger(r).PrintError(err, nil)
+		}
+	})
+
+	app.requestLogger(r).PrintInfo("account deletion requested", map[string]string{
+		"user_id":       strconv.FormatInt(user.ID, 10),
+		"deletion_date": deletionDate.Format(time.RFC3339),
+	})
+
+	env := envelope{
+		"message":       "your account will be deleted after the deletion date; log in before then to cancel",
+		"deletion_date": deletionDate,
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// processAccountDeletions runs for the lifetime of the application, deleting or
+// anonymising the accounts whose grace period has passed, and expired exports.
+func (app *application) processAccountDeletions() {
+	for {
+		err := app.models.Exports.DeleteExpired()
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+
+		ids, err := app.models.Users.GetAllDeletionsDue(time.Now().Add(-app.config.privacy.deletionGrace))
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+
+		for _, id := range ids {
+			switch app.config.privacy.deletionMode {
+			case deletionDelete:
+				err = app.models.Users.Delete(id)
+			default:
+				err = app.models.Users.Anonymise(id)
+			}
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"user_id": strconv.FormatInt(id, 10)})
+				continue
+			}
+
+			app.logger.PrintInfo("account deleted", map[string]string{
+				"user_id": strconv.FormatInt(id, 10),
+				"mode":    app.config.privacy.deletionMode,
+			})
+		}
+
+		time.Sleep(time.Hour)
+	}
+}
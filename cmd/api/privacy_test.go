@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+)
+
+func TestDeleteCurrentUser(t *testing.T) {
+	app := newTestApplication(t, newTestDB(t))
+	h := app.routes()
+
+	deleteMe := func(credential, body string) (*http.Response, map[string]any) {
+		return do(t, h, withBearer(httptest.NewRequest(http.MethodDelete, "/v1/users/me", strings.NewReader(body)), credential))
+	}
+
+	deletionDue := func(t *testing.T, userID int64) bool {
+		t.Helper()
+
+		ids, err := app.models.Users.GetAllDeletionsDue(time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return slices.Contains(ids, userID)
+	}
+
+	t.Run("confirming", func(t *testing.T) {
+		user := newTestUser(t, app, "alice@example.com", "movies:read")
+
+		magicLink, err := app.models.Tokens.New(user.ID, time.Hour, data.ScopeMagicLink)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name       string
+			body       string
+			wantStatus int
+		}{
+			{"wrong password", `{"password": "wrong password"}`, http.StatusUnprocessableEntity},
+			{"password and token", `{"password": "pa55word1234", "token": "` + magicLink.Plaintext + `"}`, http.StatusUnprocessableEntity},
+			{"made-up token", `{"token": "` + strings.Repeat("A", 26) + `"}`, http.StatusUnprocessableEntity},
+			{"nothing", `{}`, http.StatusUnprocessableEntity},
+			{"magic link token", `{"token": "` + magicLink.Plaintext + `"}`, http.StatusAccepted},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				res, body := deleteMe(newTestToken(t, app, user), tt.body)
+				if res.StatusCode != tt.wantStatus {
+					t.Errorf("got status %d; want %d (%v)", res.StatusCode, tt.wantStatus, body)
+				}
+			})
+		}
+	})
+
+	t.Run("with an API key", func(t *testing.T) {
+		user := newTestUser(t, app, "bob@example.com", "movies:read")
+
+		key, err := app.models.APIKeys.New(user.ID, "script", time.Now().Add(time.Hour), []string{"movies:read"}, []string{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, _ := deleteMe(key.Plaintext, `{"password": "pa55word1234"}`)
+		if res.StatusCode != http.StatusForbidden {
+			t.Errorf("got status %d; want %d", res.StatusCode, http.StatusForbidden)
+		}
+		if deletionDue(t, user.ID) {
+			t.Error("got a deletion requested with an API key")
+		}
+	})
+
+	t.Run("logging in cancels the deletion", func(t *testing.T) {
+		user := newTestUser(t, app, "carol@example.com", "movies:read")
+		token := newTestToken(t, app, user)
+
+		res, body := deleteMe(token, `{"password": "pa55word1234"}`)
+		if res.StatusCode != http.StatusAccepted {
+			t.Fatalf("got status %d; want %d (%v)", res.StatusCode, http.StatusAccepted, body)
+		}
+
+		if !deletionDue(t, user.ID) {
+			t.Error("got no deletion requested")
+		}
+
+		// The user is logged out everywhere.
+		res, _ = do(t, h, withBearer(httptest.NewRequest(http.MethodGet, "/v1/users/me", nil), token))
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("old session: got status %d; want %d", res.StatusCode, http.StatusUnauthorized)
+		}
+
+		r := httptest.NewRequest(http.MethodPost, "/v1/tokens/authentication", strings.NewReader(`{"email": "carol@example.com", "password": "pa55word1234"}`))
+		res, body = do(t, h, r)
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("logging in: got status %d (%v)", res.StatusCode, body)
+		}
+
+		if deletionDue(t, user.ID) {
+			t.Error("got the deletion still requested after logging in")
+		}
+	})
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/jsonlog"
+	"golang.org/x/time/rate"
+)
+
+// Rate limit route classes. Each has its own rate and burst, so that the endpoints
+// which check credentials can be limited much more tightly than browsing.
+const (
+	rateLimitRead  = "read"
+	rateLimitWrite = "write"
+	rateLimitAuth  = "auth"
+	// rateLimitFailedAuth counts the requests from each IP address which ended in a
+	// 401, see rateLimitFailures. It has the same limits as rateLimitAuth.
+	rateLimitFailedAuth = "failed-auth"
+)
+
+// Rate limiter backends, set with -limiter-backend.
+const (
+	limiterMemory   = "memory"
+	limiterPostgres = "postgres"
+)
+
+// limit is a token bucket: rps tokens are added every second, up to burst.
+type limit struct {
+	rps   float64
+	burst int
+}
+
+// interval is how long the bucket takes to gain a token.
+func (l limit) interval() time.Duration {
+	return time.Duration(float64(time.Second) / l.rps)
+}
+
+// refill is how long an empty bucket takes to fill up again.
+func (l limit) refill() time.Duration {
+	return time.Duration(float64(l.burst) / l.rps * float64(time.Second))
+}
+
+// rateLimitResult describes the client's bucket after a request, for the RateLimit-*
+// headers.
+type rateLimitResult struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+type limiterClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+	// With a shared backend, these remember what the database last said about the
+	// client's bucket: when it will be full again, how many tokens this instance has
+	// taken from it ahead of time, and until when it has none at all.
+	fullAt      time.Time
+	leased      int
+	deniedUntil time.Time
+}
+
+// rateLimiter keeps a token bucket for each client in each route class. Clients are
+// either users, for authenticated requests, or IP addresses.
+type rateLimiter struct {
+	mu      sync.Mutex
+	limits  map[string]limit
+	clients map[string]*limiterClient
+
+	// shared is set when the buckets are kept in the database, so that every instance
+	// of the API counts against the same limits. See allowShared.
+	shared     *data.RateLimitModel
+	lease      int
+	failClosed bool
+	logger     *jsonlog.Logger
+}
+
+func newRateLimiter(limits map[string]limit) *rateLimiter {
+	return &rateLimiter{
+		limits:  limits,
+		clients: make(map[string]*limiterClient),
+	}
+}
+
+// allow takes a token from the client's bucket for the class, if there is one.
+func (l *rateLimiter) allow(class, key string) rateLimitResult {
+	result := l.allowLocal(class, key)
+	if !result.allowed || l.shared == nil {
+		return result
+	}
+
+	return l.allowShared(class, key, result)
+}
+
+func (l *rateLimiter) allowLocal(class, key string) rateLimitResult {
+	lim := l.limits[class]
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	client, found := l.clients[class+":"+key]
+	if !found {
+		client = &limiterClient{limiter: rate.NewLimiter(rate.Limit(lim.rps), lim.burst)}
+		l.clients[class+":"+key] = client
+	}
+
+	client.lastSeen = now
+
+	result := rateLimitResult{
+		allowed: client.limiter.AllowN(now, 1),
+		limit:   lim.burst,
+	}
+
+	tokens := client.limiter.TokensAt(now)
+
+	result.remaining = int(math.Max(0, math.Floor(tokens)))
+	result.reset = tokenWait(lim, float64(lim.burst)-tokens)
+	if !result.allowed {
+		result.retryAfter = tokenWait(lim, 1-tokens)
+	}
+
+	return result
+}
+
+// exhausted reports whether the client's bucket for the class is empty, without
+// taking a token from it. With a shared backend, it only knows what this instance
+// last heard from the database.
+func (l *rateLimiter) exhausted(class, key string) (rateLimitResult, bool) {
+	lim := l.limits[class]
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	client, found := l.clients[class+":"+key]
+	if !found {
+		return rateLimitResult{}, false
+	}
+
+	if now.Before(client.deniedUntil) {
+		return rateLimitResult{
+			limit:      lim.burst,
+			reset:      client.fullAt.Sub(now),
+			retryAfter: client.deniedUntil.Sub(now),
+		}, true
+	}
+
+	tokens := client.limiter.TokensAt(now)
+	if tokens >= 1 {
+		return rateLimitResult{}, false
+	}
+
+	return rateLimitResult{
+		limit:      lim.burst,
+		reset:      tokenWait(lim, float64(lim.burst)-tokens),
+		retryAfter: tokenWait(lim, 1-tokens),
+	}, true
+}
+
+// allowShared checks the client's bucket in the database. The database isn't asked
+// about every request: the local bucket has already turned away clients which are over
+// the limit on this instance alone, clients the database has turned away are turned
+// away here until they have a token again, and tokens are taken lease at a time and
+// handed out locally. Leased tokens are spent as far as the other instances are
+// concerned, so a lease bigger than 1 makes the limits stricter for clients whose
+// requests are spread over several instances.
+//
+// If the database can't be reached, the request is let through on the strength of the
+// local bucket, or turned away if the limiter fails closed.
+func (l *rateLimiter) allowShared(class, key string, local rateLimitResult) rateLimitResult {
+	lim := l.limits[class]
+	now := time.Now()
+
+	l.mu.Lock()
+	client := l.clients[class+":"+key]
+
+	switch {
+	case now.Before(client.deniedUntil):
+		l.mu.Unlock()
+		return rateLimitResult{
+			limit:      lim.burst,
+			reset:      client.fullAt.Sub(now),
+			retryAfter: client.deniedUntil.Sub(now),
+		}
+	case client.leased > 0 && now.Before(client.fullAt):
+		client.leased--
+		result := sharedResult(lim, client.fullAt.Sub(now), client.leased)
+		l.mu.Unlock()
+		return result
+	}
+	l.mu.Unlock()
+
+	n := min(l.lease, lim.burst)
+
+	state, ok, err := l.shared.Take(class+":"+key, lim.interval(), lim.burst, n)
+	if err == nil && !ok && n > 1 {
+		n = 1
+		state, ok, err = l.shared.Take(class+":"+key, lim.interval(), lim.burst, n)
+	}
+	if err != nil {
+		l.logger.PrintError(err, map[string]string{"rate_limit_key": class + ":" + key})
+		if l.failClosed {
+			return rateLimitResult{limit: lim.burst, retryAfter: time.Second}
+		}
+		return local
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	client.fullAt = now.Add(state.Until)
+
+	if !ok {
+		// The bucket has a token again once it's within burst-1 tokens of full.
+		retryAfter := state.Until - time.Duration(lim.burst-1)*lim.interval()
+		client.deniedUntil = now.Add(retryAfter)
+
+		return rateLimitResult{
+			limit:      lim.burst,
+			reset:      state.Until,
+			retryAfter: retryAfter,
+		}
+	}
+
+	client.leased = n - 1
+
+	return sharedResult(lim, state.Until, client.leased)
+}
+
+// sharedResult describes a shared bucket which will be full again after until, plus
+// the tokens this instance has leased from it.
+func sharedResult(lim limit, until time.Duration, leased int) rateLimitResult {
+	remaining := int(math.Floor(float64(lim.burst)-until.Seconds()*lim.rps)) + leased
+
+	return rateLimitResult{
+		allowed:   true,
+		limit:     lim.burst,
+		remaining: max(remaining, 0),
+		reset:     until,
+	}
+}
+
+// tokenWait is how long it takes for the bucket to gain the given number of tokens.
+func tokenWait(lim limit, tokens float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	return time.Duration(tokens / lim.rps * float64(time.Second))
+}
+
+// evict runs for the lifetime of the application, removing clients whose buckets have
+// filled up again, locally and in the database. Forgetting them makes no difference,
+// as a new client starts with a full bucket.
+func (l *rateLimiter) evict() {
+	for {
+		time.Sleep(time.Minute)
+
+		if l.shared != nil {
+			err := l.shared.DeleteFull()
+			if err != nil {
+				l.logger.PrintError(err, nil)
+			}
+		}
+
+		l.mu.Lock()
+		for key, client := range l.clients {
+			class, _, _ := strings.Cut(key, ":")
+			if time.Since(client.lastSeen) > l.limits[class].refill() {
+				delete(l.clients, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// rateLimitClass decides which limits apply to a request. Anything that checks a
+// password or token given in the request body is "auth"; otherwise reads and writes
+// are told apart by the method.
+func rateLimitClass(r *http.Request) string {
+	path := r.URL.Path
+
+	switch {
+	case strings.HasPrefix(path, "/v1/tokens/"), strings.HasPrefix(path, "/v1/oidc/"), oauthClientEndpoints[path]:
+		return rateLimitAuth
+	case r.Method == http.MethodPost && path == "/v1/users":
+		return rateLimitAuth
+	case r.Method == http.MethodPut && (path == "/v1/users/activated" || path == "/v1/users/password"):
+		return rateLimitAuth
+	// Changing a password and deleting an account both check the user's password.
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "/v1/users/") && strings.HasSuffix(path, "/password"):
+		return rateLimitAuth
+	case r.Method == http.MethodDelete && path == "/v1/users/me":
+		return rateLimitAuth
+	case r.Method == http.MethodGet, r.Method == http.MethodHead, r.Method == http.MethodOptions:
+		return rateLimitRead
+	default:
+		return rateLimitWrite
+	}
+}
+
+// setRateLimitHeaders sets the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// headers from the IETF draft, and Retry-After on rejected requests. Times are rounded
+// up to whole seconds, so that clients which wait that long are let through.
+func setRateLimitHeaders(w http.ResponseWriter, result rateLimitResult) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.reset)))
+
+	if !result.allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.retryAfter)))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
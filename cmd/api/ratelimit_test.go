@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimitClass(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{http.MethodPost, "/v1/tokens/authentication", rateLimitAuth},
+		{http.MethodGet, "/v1/oidc/callback", rateLimitAuth},
+		{http.MethodPost, "/v1/oauth/token", rateLimitAuth},
+		{http.MethodPost, "/v1/oauth/introspect", rateLimitAuth},
+		{http.MethodPost, "/v1/oauth/revoke", rateLimitAuth},
+		{http.MethodPost, "/v1/users", rateLimitAuth},
+		{http.MethodPut, "/v1/users/activated", rateLimitAuth},
+		{http.MethodPut, "/v1/users/password", rateLimitAuth},
+		{http.MethodPut, "/v1/users/me/password", rateLimitAuth},
+		{http.MethodPut, "/v1/users/42/password", rateLimitAuth},
+		{http.MethodDelete, "/v1/users/me", rateLimitAuth},
+		{http.MethodDelete, "/v1/users/42", rateLimitWrite},
+		{http.MethodGet, "/v1/users/me", rateLimitRead},
+		{http.MethodGet, "/v1/movies", rateLimitRead},
+		{http.MethodPost, "/v1/movies", rateLimitWrite},
+		{http.MethodGet, "/v1/oauth/authorize", rateLimitRead},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if got := rateLimitClass(r); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+		Parents     []string `json:"parents"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	role := &data.Role{
+		Name:        input.Name,
+		Description: input.Description,
+		Permissions: input.Permissions,
+		Parents:     input.Parents,
+	}
+
+	// Permissions and parents can be left out of a new role.
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+	if role.Parents == nil {
+		role.Parents = []string{}
+	}
+
+	v := validator.New()
+
+	err = app.validateRole(v, role)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Roles.Insert(role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateRoleName):
+			v.AddError("name", "a role with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, data.AuditRoleCreated, 0, roleAuditDetails(role))
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/roles/%d", role.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"role": role}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showRoleHandler(w http.ResponseWriter, r *http.Request) {
+	role, err := app.roleForIDParam(w, r)
+	if err != nil {
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"role": role}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateRoleHandler replaces whichever of the role's fields are given. Permissions
+// and parents are replaced as a whole, not merged.
+func (app *application) updateRoleHandler(w http.ResponseWriter, r *http.Request) {
+	role, err := app.roleForIDParam(w, r)
+	if err != nil {
+		return
+	}
+
+	var input struct {
+		Name        *string  `json:"name"`
+		Description *string  `json:"description"`
+		Permissions []string `json:"permissions"`
+		Parents     []string `json:"parents"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		role.Name = *input.Name
+	}
+
+	if input.Description != nil {
+		role.Description = *input.Description
+	}
+
+	if input.Permissions != nil {
+		role.Permissions = input.Permissions
+	}
+
+	if input.Parents != nil {
+		role.Parents = input.Parents
+	}
+
+	v := validator.New()
+
+	err = app.validateRole(v, role)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Roles.Update(role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateRoleName):
+			v.AddError("name", "a role with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRoleCycle):
+			v.AddError("parents", "must not include a role which inherits from this one")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, data.AuditRoleUpdated, 0, roleAuditDetails(role))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"role": role}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteRoleHandler deletes a role. Users lose the permissions they had through it
+// and roles which inherited from it stop doing so.
+func (app *application) deleteRoleHandler(w http.ResponseWriter, r *http.Request) {
+	role, err := app.roleForIDParam(w, r)
+	if err != nil {
+		return
+	}
+
+	err = app.models.Roles.Delete(role.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, data.AuditRoleDeleted, 0, roleAuditDetails(role))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "role successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The listUserRolesHandler is used both by admins, for any user, and by users for
+// themselves through /v1/users/me/roles.
+func (app *application) listUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := app.contextGetUser(r).ID
+
+	if httprouter.ParamsFromContext(r.Context()).ByName("id") != "me" {
+		user, err := app.userForIDParam(w, r)
+		if err != nil {
+			return
+		}
+		userID = user.ID
+	}
+
+	roles, err := app.models.Roles.GetAllForUser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) assignUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeUserRoles(w, r, data.PermissionAssignRole)
+}
+
+func (app *application) unassignUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeUserRoles(w, r, data.PermissionUnassignRole)
+}
+
+// changeUserRoles assigns or unassigns the roles named in the request body and records
+// the change alongside permission grants and revocations. Like changeUserPermissions
+// it's idempotent.
+func (app *application) changeUserRoles(w http.ResponseWriter, r *http.Request, action string) {
+	user, err := app.userForIDParam(w, r)
+	if err != nil {
+		return
+	}
+
+	var input struct {
+		Roles []string `json:"roles"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	names, err := app.roleNames()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Roles) > 0, "roles", "must contain at least 1 role")
+	v.Check(validator.Unique(input.Roles), "roles", "must not contain duplicate values")
+	validateRoleNames(v, "roles", names, input.Roles)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	actor := app.contextGetUser(r)
+
+	change := &data.PermissionChange{
+		ActorID: actor.ID,
+		UserID:  user.ID,
+		Action:  action,
+		Codes:   input.Roles,
+	}
+
+	err = app.models.PermissionChanges.Apply(change)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	auditAction := data.AuditRoleAssigned
+	if action == data.PermissionUnassignRole {
+		auditAction = data.AuditRoleUnassigned
+	}
+	app.audit(r, auditAction, user.ID, map[string]string{"roles": strings.Join(input.Roles, ",")})
+
+	app.requestLogger(r).PrintInfo("roles changed", map[string]string{
+		"action":   action,
+		"actor_id": strconv.FormatInt(actor.ID, 10),
+		"user_id":  strconv.FormatInt(user.ID, 10),
+		"roles":    strings.Join(input.Roles, ","),
+	})
+
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// validateRole runs data.ValidateRole and also checks that the role's permissions and
+// parents exist, which needs the database.
+func (app *application) validateRole(v *validator.Validator, role *data.Role) error {
+	data.ValidateRole(v, role)
+
+	permissions, err := app.models.Permissions.GetAll()
+	if err != nil {
+		return err
+	}
+
+	names, err := app.roleNames()
+	if err != nil {
+		return err
+	}
+
+	validatePermissionCodes(v, permissions, role.Permissions)
+	validateRoleNames(v, "parents", names, role.Parents)
+
+	return nil
+}
+
+// roleNames returns the names of every role, lower-cased because role names are
+// compared case-insensitively.
+func (app *application) roleNames() ([]string, error) {
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(roles))
+	for i, role := range roles {
+		names[i] = strings.ToLower(role.Name)
+	}
+
+	return names, nil
+}
+
+func validateRoleNames(v *validator.Validator, key string, all, names []string) {
+	for _, name := range names {
+		if !slices.Contains(all, strings.ToLower(name)) {
+			v.AddError(key, fmt.Sprintf("%q is not a role", name))
+		}
+	}
+}
+
+// roleAuditDetails describes a role in an audit event. Roles aren't users, so they go
+// in the details rather than being the event's subject.
+func roleAuditDetails(role *data.Role) map[string]string {
+	return map[string]string{
+		"role_id":     strconv.FormatInt(role.ID, 10),
+		"role":        role.Name,
+		"permissions": strings.Join(role.Permissions, ","),
+		"parents":     strings.Join(role.Parents, ","),
+	}
+}
+
+// roleForIDParam fetches the role named by the :id parameter, sending the error
+// response itself like userForIDParam.
+func (app *application) roleForIDParam(w http.ResponseWriter, r *http.Request) (*data.Role, error) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, err
+	}
+
+	role, err := app.models.Roles.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, err
+	}
+
+	return role, nil
+}
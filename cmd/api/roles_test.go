@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRoles(t *testing.T) {
+	app := newTestApplication(t, newTestDB(t))
+	h := app.routes()
+
+	admin := newTestUser(t, app, "admin@example.com", "permissions:admin")
+	adminToken := newTestToken(t, app, admin)
+
+	user := newTestUser(t, app, "alice@example.com")
+	userPath := "/v1/users/" + strconv.FormatInt(user.ID, 10)
+
+	send := func(method, path, body string) (*http.Response, map[string]any) {
+		return do(t, h, withBearer(httptest.NewRequest(method, path, strings.NewReader(body)), adminToken))
+	}
+
+	res, body := send(http.MethodPost, "/v1/roles", `{"name": "reader", "permissions": ["movies:read"]}`)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("creating reader: got status %d (%v)", res.StatusCode, body)
+	}
+	readerPath := res.Header.Get("Location")
+
+	res, body = send(http.MethodPost, "/v1/roles", `{"name": "writer", "permissions": ["movies:write"], "parents": ["Reader"]}`)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("creating writer: got status %d (%v)", res.StatusCode, body)
+	}
+
+	res, body = send(http.MethodPut, userPath+"/roles", `{"roles": ["writer"]}`)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("assigning writer: got status %d (%v)", res.StatusCode, body)
+	}
+
+	wantPermissions := func(t *testing.T, want ...string) {
+		t.Helper()
+
+		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		slices.Sort(permissions)
+		if !slices.Equal(permissions, want) {
+			t.Errorf("got permissions %v; want %v", permissions, want)
+		}
+	}
+
+	// The user has the writer role's permissions and those it inherits from reader.
+	wantPermissions(t, "movies:read", "movies:write")
+
+	t.Run("users holding a permission through a role", func(t *testing.T) {
+		res, body := send(http.MethodGet, "/v1/permissions/movies:read/users", "")
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("got status %d (%v)", res.StatusCode, body)
+		}
+
+		users, _ := body["users"].([]any)
+		found := slices.ContainsFunc(users, func(u any) bool {
+			m, _ := u.(map[string]any)
+			return m["id"] == float64(user.ID)
+		})
+		if !found {
+			t.Errorf("got users %v; want them to include %d", users, user.ID)
+		}
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"duplicate name", http.MethodPost, "/v1/roles", `{"name": "READER"}`, http.StatusUnprocessableEntity},
+		{"unknown permission", http.MethodPost, "/v1/roles", `{"name": "flyer", "permissions": ["movies:fly"]}`, http.StatusUnprocessableEntity},
+		{"unknown parent", http.MethodPost, "/v1/roles", `{"name": "orphan", "parents": ["nobody"]}`, http.StatusUnprocessableEntity},
+		{"inheriting from itself", http.MethodPatch, readerPath, `{"parents": ["reader"]}`, http.StatusUnprocessableEntity},
+		{"inheritance cycle", http.MethodPatch, readerPath, `{"parents": ["writer"]}`, http.StatusUnprocessableEntity},
+		{"assigning an unknown role", http.MethodPut, userPath + "/roles", `{"roles": ["nobody"]}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, body := send(tt.method, tt.path, tt.body)
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d; want %d (%v)", res.StatusCode, tt.wantStatus, body)
+			}
+		})
+	}
+
+	// Deleting a role takes its permissions away from users who had them through it,
+	// including through roles inheriting from it.
+	res, body = send(http.MethodDelete, readerPath, "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("deleting reader: got status %d (%v)", res.StatusCode, body)
+	}
+
+	wantPermissions(t, "movies:write")
+}
@@ -36,18 +36,92 @@ func (app *application) routes() http.Handler {
 	// router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requireActivatedUser(app.deleteMovieHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
 
+	// Editing a movie also needs the user to own it or collaborate on it, see requireMovieEditor().
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/collaborators", app.requirePermission("movies:write", app.listMovieCollaboratorsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/collaborators", app.requirePermission("movies:write", app.addMovieCollaboratorHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/collaborators/:user_id", app.requirePermission("movies:write", app.removeMovieCollaboratorHandler))
+
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/invitations", app.requirePermission("users:admin", app.createInvitationHandler))
 
 	// Using PUT because nothing in our application state (i.e. database) changes after that first request
-	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	// router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/:id", app.routeByID(map[string]http.HandlerFunc{
+		"activated": app.activateUserHandler,
+		"password":  app.resetUserPasswordHandler,
+	}, nil))
+
+	// Routes under /v1/users/:id accept "me" in place of the ID, see selfOr().
+	router.HandlerFunc(http.MethodGet, "/v1/users", app.requirePermission("users:admin", app.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id", app.selfOr(app.requireActivatedUser(app.showUserHandler), app.requirePermission("users:admin", app.showUserHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/:id", app.requirePermission("users:admin", app.updateUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id", app.selfOr(app.requireActivatedUser(app.deleteCurrentUserHandler), app.requirePermission("users:admin", app.deleteUserHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:id/export", app.selfOr(app.requireActivatedUser(app.createDataExportHandler), nil))
+	router.HandlerFunc(http.MethodGet, "/v1/exports/:token", app.downloadDataExportHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/users/:id/impersonation", app.requirePermission("users:admin", app.createImpersonationTokenHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/api-keys", app.selfOr(app.requireActivatedUser(app.listAPIKeysHandler), nil))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:id/api-keys", app.selfOr(app.requireActivatedUser(app.createAPIKeyHandler), nil))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/api-keys/:key_id", app.selfOr(app.requireActivatedUser(app.deleteAPIKeyHandler), nil))
+
+	router.HandlerFunc(http.MethodPut, "/v1/users/:id/password", app.selfOr(app.requireActivatedUser(app.changeUserPasswordHandler), nil))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/notifications", app.selfOr(app.requireActivatedUser(app.showNotificationSettingsHandler), nil))
+	router.HandlerFunc(http.MethodPut, "/v1/users/:id/notifications", app.selfOr(app.requireActivatedUser(app.updateNotificationSettingsHandler), nil))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/permissions", app.selfOr(app.requireActivatedUser(app.showUserPermissionsHandler), app.requirePermission("permissions:admin", app.showUserPermissionsHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/:id/permissions", app.requirePermission("permissions:admin", app.grantUserPermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/permissions", app.requirePermission("permissions:admin", app.revokeUserPermissionsHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/permissions", app.requirePermission("permissions:admin", app.listPermissionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/permissions/:code/users", app.requirePermission("permissions:admin", app.listPermissionUsersHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/audit", app.requirePermission("audit:read", app.listAuditEventsHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/roles", app.requirePermission("permissions:admin", app.listRolesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/roles", app.requirePermission("permissions:admin", app.createRoleHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/roles/:id", app.requirePermission("permissions:admin", app.showRoleHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/roles/:id", app.requirePermission("permissions:admin", app.updateRoleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/roles/:id", app.requirePermission("permissions:admin", app.deleteRoleHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/roles", app.selfOr(app.requireActivatedUser(app.listUserRolesHandler), app.requirePermission("permissions:admin", app.listUserRolesHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/:id/roles", app.requirePermission("permissions:admin", app.assignUserRolesHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/roles", app.requirePermission("permissions:admin", app.unassignUserRolesHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/users/:id/totp", app.selfOr(app.requireActivatedUser(app.enrolTOTPHandler), nil))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:id/totp/confirm", app.selfOr(app.requireActivatedUser(app.confirmTOTPHandler), nil))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/totp", app.selfOr(app.requireActivatedUser(app.disableTOTPHandler), app.requirePermission("users:admin", app.resetTOTPHandler)))
 
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createLoginAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/mfa", app.createMFAAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/unlock", app.unlockAccountHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/secure-account", app.secureAccountHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link", app.createMagicLinkTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link/exchange", app.exchangeMagicLinkTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/revocations", app.requirePermission("tokens:revoke", app.createTokenRevocationHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/login", app.oidcLoginHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/callback", app.oidcCallbackHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/oauth/clients", app.requireActivatedUser(app.listOAuthClientsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/oauth/clients", app.requireActivatedUser(app.registerOAuthClientHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/oauth/clients/:id", app.requireActivatedUser(app.deleteOAuthClientHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/oauth/authorize", app.requireActivatedUser(app.showAuthorizationRequestHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/oauth/authorize", app.requireActivatedUser(app.authorizeHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/oauth/token", app.oauthTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/oauth/introspect", app.introspectTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/oauth/revoke", app.revokeTokenHandler)
+
+	router.HandlerFunc(http.MethodGet, "/.well-known/jwks.json", app.jwksHandler)
+	router.HandlerFunc(http.MethodGet, "/.well-known/oauth-authorization-server", app.authorizationServerMetadataHandler)
 
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
+	router.Handler(http.MethodGet, "/metrics", app.metricSet.registry.Handler())
 
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	return app.requestID(app.accessLog(router, app.metrics(router, app.recoverPanic(app.enableCORS(app.rateLimitFailures(app.authenticate(app.rateLimit(router))))))))
 
 	// return app.recoverPanic(router)
 	// return router
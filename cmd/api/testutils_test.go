@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/jsonlog"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/mailer"
+)
+
+// newTestDB opens the database named by GREENLIGHT_TEST_DB_DSN and runs the migrations
+// in a schema of its own, which is dropped when the test finishes. The citext extension
+// has to be installed in the database's public schema already. Tests which need a
+// database are skipped when GREENLIGHT_TEST_DB_DSN isn't set.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("GREENLIGHT_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("GREENLIGHT_TEST_DB_DSN not set")
+	}
+
+	suffix := make([]byte, 6)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := "test_" + hex.EncodeToString(suffix)
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	_, err = admin.Exec(`CREATE SCHEMA ` + schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// lib/pq passes unknown parameters on to the server, so every connection in the
+	// pool gets the search path.
+	if strings.Contains(dsn, "://") {
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		dsn += separator + "search_path=" + url.QueryEscape(schema+",public")
+	} else {
+		dsn += " search_path=" + schema + ",public"
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		db.Close()
+
+		admin, err := sql.Open("postgres", os.Getenv("GREENLIGHT_TEST_DB_DSN"))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer admin.Close()
+
+		_, err = admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	migrations, err := filepath.Glob("../../migrations/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(migrations)
+
+	for _, migration := range migrations {
+		script, err := os.ReadFile(migration)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = db.Exec(string(script))
+		if err != nil {
+			t.Fatalf("%s: %s", filepath.Base(migration), err)
+		}
+	}
+
+	return db
+}
+
+// newTestApplication returns an application with the defaults main() would give it,
+// logging nowhere. With a nil db, handlers which touch the database can't be used.
+func newTestApplication(t *testing.T, db *sql.DB) *application {
+	t.Helper()
+
+	logger := jsonlog.New(io.Discard, jsonlog.LevelInfo)
+
+	app := &application{
+		logger:       logger,
+		accessLogger: logger,
+		metricSet:    newAppMetrics(db),
+		models:       data.NewModels(db),
+		// Nothing listens on port 1, so emails fail straight away.
+		mailer: mailer.New("localhost", 1, "", "", "Greenlight <no-reply@greenlight.example.com>"),
+	}
+
+	app.config.env = "development"
+	app.config.oauth.issuer = "http://localhost:4000"
+	app.config.oauth.tokenTTL = time.Hour
+	app.config.oauth.codeTTL = time.Minute
+	app.config.lockout.maxFailures = 5
+	app.config.lockout.ipMaxFailures = 20
+	app.config.lockout.duration = 15 * time.Minute
+	app.config.registration.mode = registrationOpen
+	app.config.privacy.deletionGrace = 7 * 24 * time.Hour
+	app.config.privacy.deletionMode = deletionDelete
+	app.limiter = app.newRateLimiter()
+
+	t.Cleanup(app.wg.Wait)
+
+	return app
+}
+
+// newTestUser inserts an activated user with the given permissions.
+func newTestUser(t *testing.T, app *application, email string, permissions ...string) *data.User {
+	t.Helper()
+
+	user := &data.User{Name: "Test User", Email: email, Activated: true}
+
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(permissions) > 0 {
+		err = app.models.Permissions.AddForUser(user.ID, permissions...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return user
+}
+
+// newTestToken returns an authentication token for the user.
+func newTestToken(t *testing.T, app *application, user *data.User) string {
+	t.Helper()
+
+	token, err := app.models.Tokens.New(user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token.Plaintext
+}
+
+// do sends a request through the full middleware chain and returns the response, with
+// the JSON body decoded into a map if there is one.
+func do(t *testing.T, h http.Handler, r *http.Request) (*http.Response, map[string]any) {
+	t.Helper()
+
+	if r.Header.Get("Content-Type") == "" && r.Method != http.MethodGet {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	r.RemoteAddr = "192.0.2.1:1234"
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+
+	res := rr.Result()
+
+	var body map[string]any
+	if strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") {
+		err := json.NewDecoder(res.Body).Decode(&body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return res, body
+}
+
+func withBearer(r *http.Request, token string) *http.Request {
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
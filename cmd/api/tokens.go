@@ -3,9 +3,11 @@ package main
 import (
 	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/passhash"
 	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
 )
 
@@ -23,17 +25,41 @@ func (app *application) createLoginAuthenticationTokenHandler(w http.ResponseWri
 	v := validator.New()
 
 	data.ValidateEmail(v, input.Email)
-	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidatePasswordAttempt(v, input.Password)
 
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
+	// Refuse to even check the password while the account or client IP is backed off
+	// after failed logins.
+	accountKey, ipKey := app.loginKeys(r, input.Email)
+
+	retryAfter, err := app.loginRetryAfter(accountKey, ipKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.loginLockedResponse(w, r, retryAfter)
+		return
+	}
+
 	user, err := app.models.Users.GetByEmail(input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			// Take as long as checking a real password would, and count the failure
+			// just the same, so unknown addresses look like wrong passwords.
+			data.DummyPasswordMatches(input.Password)
+
+			err = app.recordLoginFailure(r, accountKey, ipKey, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -47,18 +73,234 @@ func (app *application) createLoginAuthenticationTokenHandler(w http.ResponseWri
 		return
 	}
 	if !match {
+		err = app.recordLoginFailure(r, accountKey, ipKey, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	// Upgrade the hash if it was made with an older algorithm or weaker parameters.
+	// We only have the plaintext now, so this can't be done in a migration. Failing to
+	// upgrade isn't a reason to fail the login.
+	if user.Password.NeedsRehash() {
+		err = app.rehashPassword(user, input.Password)
+		if err != nil {
+			app.requestLogger(r).PrintError(err, map[string]string{"user_id": strconv.FormatInt(user.ID, 10)})
+		}
+	}
+
+	// The IP's failures are kept, otherwise an attacker could reset them by logging in
+	// to an account of their own now and then.
+	err = app.models.LoginFailures.Clear(accountKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+// The createPasswordResetTokenHandler emails a password reset token to the user. It
+// responds the same way whether or not the address belongs to an account, so it can't
+// be used to find out which addresses do.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user != nil && user.Activated {
+		token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.background(r, func() {
+			data := map[string]any{
+				"passwordResetToken": token.Plaintext,
+			}
+
+			err = app.requestMailer(r).Send(user.Email, "token_password_reset.tmpl", data)
+			if err != nil {
+				app.requestLogger(r).PrintError(err, nil)
+			}
+		})
+	}
+
+	env := envelope{"message": "if the address belongs to an activated account, an email will be sent to it containing password reset instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) rehashPassword(user *data.User, plaintextPassword string) error {
+	err := user.Password.Set(plaintextPassword)
+	if err != nil {
+		// The password can't be hashed with the current hasher (it's longer than bcrypt
+		// allows), so keep the hash it has.
+		if errors.Is(err, passhash.ErrPasswordTooLong) {
+			return nil
+		}
+		return err
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		// Someone else updated the user at the same time; the rehash can wait.
+		if errors.Is(err, data.ErrEditConflict) {
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
+
+// completeLogin responds to a login, by password or magic link, which has proved the
+// user's first factor. Users with two-factor authentication get a short-lived token
+// which is only good for POST /v1/tokens/mfa, where it's exchanged along with a code
+// for the real thing; everyone else gets an authentication token straight away.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *data.User) {
+	enrolment, err := app.models.TOTP.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if enrolment != nil && enrolment.Confirmed {
+		mfaToken, err := app.models.Tokens.New(user.ID, 5*time.Minute, data.ScopeMFAPending)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusAccepted, envelope{"mfa_pending_token": mfaToken}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	token, err := app.newAuthenticationToken(r, user)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	app.audit(r, data.AuditLoginSucceeded, user.ID, map[string]string{"path": r.URL.Path})
+	app.notifyLogin(r, user)
+
 	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// newAuthenticationToken issues an authentication token for a user who has just logged
+// in. In stateless mode the client gets a signed JWT instead of an opaque token which
+// is stored in the tokens table. Both are returned in the same shape.
+func (app *application) newAuthenticationToken(r *http.Request, user *data.User) (*data.Token, error) {
+	// Logging in during the grace period after asking for the account to be deleted
+	// calls the deletion off.
+	cancelled, err := app.models.Users.CancelDeletion(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if cancelled {
+		app.requestLogger(r).PrintInfo("account deletion cancelled", map[string]string{"user_id": strconv.FormatInt(user.ID, 10)})
+	}
+
+	if app.config.jwt.enabled {
+		return app.newStatelessAuthenticationToken(user)
+	}
+
+	return app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+}
+
+// logOutEverywhere revokes every credential the user could be using: their
+// authentication and pending two-factor tokens, OAuth access tokens and API keys, and
+// in stateless mode every JWT issued to them so far. Magic link and password reset
+// tokens go too, as either could be exchanged for a new session. The revocation is
+// audited with the reason given.
+func (app *application) logOutEverywhere(r *http.Request, userID int64, reason string) error {
+	return app.revokeSessions(r, userID, reason, nil)
+}
+
+// logOutElsewhere is logOutEverywhere for a user securing their own account: the
+// authentication token they made the request with is kept, so they stay logged in. A
+// JWT can't be kept, since JWTs are revoked by when they were issued, so in stateless
+// mode the user has to log in again.
+func (app *application) logOutElsewhere(r *http.Request, userID int64, reason string) error {
+	token, _ := app.contextGetToken(r)
+	return app.revokeSessions(r, userID, reason, token)
+}
+
+func (app *application) revokeSessions(r *http.Request, userID int64, reason string, keep *data.Token) error {
+	scopes := []string{
+		data.ScopeAuthentication,
+		data.ScopeMFAPending,
+		data.ScopeOAuthAccess,
+		data.ScopeMagicLink,
+		data.ScopePasswordReset,
+	}
+
+	for _, scope := range scopes {
+		var err error
+		if keep != nil && keep.Scope == scope {
+			err = app.models.Tokens.DeleteAllForUserExcept(scope, userID, keep.Hash)
+		} else {
+			err = app.models.Tokens.DeleteAllForUser(scope, userID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err := app.models.APIKeys.DeleteAllForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	if app.config.jwt.enabled {
+		revocation := &data.Revocation{
+			UserID: &userID,
+			Expiry: time.Now().Add(app.config.jwt.ttl),
+		}
+
+		err = app.models.Revocations.Insert(revocation)
+		if err != nil {
+			return err
+		}
+
+		app.denylist.add(revocation)
+	}
+
+	app.audit(r, data.AuditTokenRevoked, userID, map[string]string{"scope": "all", "reason": reason})
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/passhash"
+)
+
+func TestLogOutEverywhere(t *testing.T) {
+	app := newTestApplication(t, newTestDB(t))
+
+	user := newTestUser(t, app, "alice@example.com", "movies:read")
+	other := newTestUser(t, app, "bob@example.com", "movies:read")
+
+	scopes := []string{
+		data.ScopeAuthentication,
+		data.ScopeMFAPending,
+		data.ScopeMagicLink,
+		data.ScopePasswordReset,
+	}
+
+	tokens := map[string]string{}
+	otherTokens := map[string]string{}
+
+	for _, scope := range scopes {
+		token, err := app.models.Tokens.New(user.ID, time.Hour, scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[scope] = token.Plaintext
+
+		token, err = app.models.Tokens.New(other.ID, time.Hour, scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		otherTokens[scope] = token.Plaintext
+	}
+
+	err := app.logOutEverywhere(httptest.NewRequest(http.MethodPost, "/", nil), user.ID, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, scope := range scopes {
+		t.Run(scope, func(t *testing.T) {
+			_, err := app.models.Users.GetForToken(scope, tokens[scope])
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				t.Errorf("got error %v for the user's token; want %v", err, data.ErrRecordNotFound)
+			}
+
+			_, err = app.models.Users.GetForToken(scope, otherTokens[scope])
+			if err != nil {
+				t.Errorf("got error %v for another user's token", err)
+			}
+		})
+	}
+}
+
+// A password longer than bcrypt allows, set while argon2id was the hasher, still logs
+// in after switching to bcrypt, and keeps its argon2id hash.
+func TestLoginWithLongPasswordAfterHasherChange(t *testing.T) {
+	app := newTestApplication(t, newTestDB(t))
+	h := app.routes()
+
+	hasher := data.PasswordHasher
+	t.Cleanup(func() { data.PasswordHasher = hasher })
+
+	data.PasswordHasher = passhash.DefaultArgon2id
+
+	password := strings.Repeat("long passphrase ", 10)
+
+	user := &data.User{Name: "Alice", Email: "alice@example.com", Activated: true}
+
+	err := user.Password.Set(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data.PasswordHasher = passhash.DefaultBcrypt
+
+	tests := []struct {
+		name       string
+		password   string
+		wantStatus int
+	}{
+		{"right password", password, http.StatusCreated},
+		{"wrong password", password + "x", http.StatusUnauthorized},
+		{"absurdly long password", strings.Repeat("x", 2000), http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/tokens/authentication", strings.NewReader(`{"email": "alice@example.com", "password": "`+tt.password+`"}`))
+			res, body := do(t, h, r)
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d; want %d (%v)", res.StatusCode, tt.wantStatus, body)
+			}
+		})
+	}
+
+	user, err = app.models.Users.Get(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !user.Password.NeedsRehash() {
+		t.Error("got the argon2id hash replaced; want it kept")
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/totp"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
+)
+
+// The enrolTOTPHandler starts two-factor enrolment. It returns the new secret and the
+// otpauth:// URI to show as a QR code; 2FA only takes effect once the user has proved
+// their authenticator works by confirming a first code.
+func (app *application) enrolTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if app.isDelegatedCredential(r) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	// The user in the context may have come from a JWT, which doesn't carry the email
+	// address we need for the provisioning URI.
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.TOTP.Enrol(user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			v := validator.New()
+			v.AddError("totp", "two-factor authentication is already enabled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	response := envelope{
+		"totp": envelope{
+			"secret":           secret,
+			"provisioning_uri": totp.URI("Greenlight", user.Email, secret),
+		},
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, response, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The confirmTOTPHandler completes enrolment with a first code from the authenticator
+// and returns the user's recovery codes. They are never shown again.
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if app.isDelegatedCredential(r) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	enrolment, err := app.models.TOTP.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("totp", "two-factor authentication has not been enrolled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if enrolment.Confirmed {
+		v.AddError("totp", "two-factor authentication is already enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	step, ok := totp.Validate(enrolment.Secret, input.Code, time.Now(), enrolment.LastUsedStep)
+	if !ok {
+		v.AddError("code", "is not valid")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	codes, err := app.models.TOTP.Confirm(user.ID, step, 10)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			v.AddError("code", "is not valid")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.notify(r, user.ID, data.NotificationTOTPEnabled)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The disableTOTPHandler lets users turn two-factor authentication off. It asks for a
+// current code or a recovery code, so that a stolen session alone isn't enough.
+func (app *application) disableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if app.isDelegatedCredential(r) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	enrolment, err := app.models.TOTP.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// An enrolment that was never confirmed can be dropped without a code.
+	if enrolment.Confirmed {
+		ok, err := app.checkSecondFactor(enrolment, input.Code, input.RecoveryCode)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !ok {
+			app.invalidCredentialsResponse(w, r)
+			return
+		}
+	}
+
+	err = app.models.TOTP.Delete(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if enrolment.Confirmed {
+		app.notify(r, user.ID, data.NotificationTOTPDisabled)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication successfully disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The resetTOTPHandler lets an admin turn two-factor authentication off for a user who
+// has lost both their authenticator and their recovery codes. As with impersonation,
+// it can't be used on other admins or on users with permissions the admin lacks.
+func (app *application) resetTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.userForIDParam(w, r)
+	if err != nil {
+		return
+	}
+
+	ok, err := app.actorCovers(r, app.contextGetUser(r), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err = app.models.TOTP.Delete(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.requestLogger(r).PrintInfo("two-factor authentication reset", map[string]string{
+		"user_id":  strconv.FormatInt(user.ID, 10),
+		"admin_id": strconv.FormatInt(app.contextGetUser(r).ID, 10),
+	})
+
+	app.audit(r, data.AuditTOTPReset, user.ID, nil)
+
+	app.notify(r, user.ID, data.NotificationTOTPDisabled)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication successfully reset"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The createMFAAuthenticationTokenHandler is the second step of logging in for users
+// with 2FA enabled. It exchanges the mfa_pending token from the first step, together
+// with a code or a recovery code, for a real authentication token.
+func (app *application) createMFAAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateTokenPlaintext(v, input.MFAToken)
+	v.Check(input.Code != "" || input.RecoveryCode != "", "code", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeMFAPending, input.MFAToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	enrolment, err := app.models.TOTP.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ok, err := app.checkSecondFactor(enrolment, input.Code, input.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Whatever the outcome, the mfa_pending token has been used up. A wrong code sends
+	// the user back to the password step, so codes can't be guessed at leisure.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeMFAPending, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		app.audit(r, data.AuditLoginFailed, user.ID, map[string]string{"factor": "totp"})
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	token, err := app.newAuthenticationToken(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, data.AuditLoginSucceeded, user.ID, map[string]string{"path": r.URL.Path})
+	app.notifyLogin(r, user)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkSecondFactor checks a TOTP code, or failing that a recovery code, against a
+// user's enrolment. Both can only be used once.
+func (app *application) checkSecondFactor(enrolment *data.TOTP, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		step, ok := totp.Validate(enrolment.Secret, code, time.Now(), enrolment.LastUsedStep)
+		if !ok {
+			return false, nil
+		}
+
+		// UseStep fails if a concurrent request got in first with the same code.
+		err := app.models.TOTP.UseStep(enrolment.UserID, step)
+		if err != nil {
+			if errors.Is(err, data.ErrEditConflict) {
+				return false, nil
+			}
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	if recoveryCode != "" && enrolment.Confirmed {
+		err := app.models.TOTP.ConsumeRecoveryCode(enrolment.UserID, recoveryCode)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return false, nil
+			}
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
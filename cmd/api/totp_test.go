@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/totp"
+)
+
+func TestResetTOTP(t *testing.T) {
+	app := newTestApplication(t, newTestDB(t))
+	h := app.routes()
+
+	admin := newTestUser(t, app, "admin@example.com", "users:admin", "movies:read")
+	adminToken := newTestToken(t, app, admin)
+
+	tests := []struct {
+		name        string
+		permissions []string
+		self        bool
+		wantStatus  int
+	}{
+		{"user with a subset of the admin's permissions", []string{"movies:read"}, false, http.StatusOK},
+		{"another admin", []string{"users:admin"}, false, http.StatusForbidden},
+		{"user with a permission the admin lacks", []string{"movies:write"}, false, http.StatusForbidden},
+		{"the admin themselves", nil, true, http.StatusForbidden},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := admin
+			if !tt.self {
+				user = newTestUser(t, app, "user"+strconv.Itoa(i)+"@example.com", tt.permissions...)
+			}
+
+			secret, err := totp.GenerateSecret()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = app.models.TOTP.Enrol(user.ID, secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := withBearer(httptest.NewRequest(http.MethodDelete, "/v1/users/"+strconv.FormatInt(user.ID, 10)+"/totp", nil), adminToken)
+			res, body := do(t, h, r)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d; want %d (%v)", res.StatusCode, tt.wantStatus, body)
+			}
+
+			_, err = app.models.TOTP.Get(user.ID)
+			if reset := errors.Is(err, data.ErrRecordNotFound); reset != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("got two-factor authentication reset %t; want %t", reset, tt.wantStatus == http.StatusOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Impersonation tokens are deliberately short-lived; support staff can always ask for
+// another one.
+const impersonationTTL = time.Hour
+
+// The listUsersHandler lists users for operators, optionally only those whose name or
+// email address contains the q query string parameter.
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Search string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Search = app.readString(qs, "q", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.GetAll(input.Search, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The showUserHandler is used both by admins, for any user, and by users for themselves
+// through /v1/users/me.
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	var user *data.User
+	var err error
+
+	if httprouter.ParamsFromContext(r.Context()).ByName("id") == "me" {
+		user, err = app.models.Users.Get(app.contextGetUser(r).ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	} else {
+		user, err = app.userForIDParam(w, r)
+		if err != nil {
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The updateUserHandler lets operators deactivate a user, reactivate them, or activate
+// an account whose owner never used their activation token. Deactivated users can't use
+// any endpoint which needs an activated account, and are logged out everywhere, see
+// logOutEverywhere().
+func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.userForIDParam(w, r)
+	if err != nil {
+		return
+	}
+
+	var input struct {
+		Activated *bool `json:"activated"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	actor := app.contextGetUser(r)
+
+	v := validator.New()
+
+	v.Check(input.Activated != nil, "activated", "must be provided")
+
+	if input.Activated != nil && !*input.Activated {
+		v.Check(user.ID != actor.ID, "activated", "you can't deactivate yourself")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	wasActivated := user.Activated
+	user.Activated = *input.Activated
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	switch {
+	case wasActivated && !user.Activated:
+		err = app.logOutEverywhere(r, user.ID, "deactivated")
+	case !wasActivated && user.Activated:
+		// Same as activating with a token, bar the token.
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+		if err == nil {
+			err = app.models.Permissions.AddForUser(user.ID, data.DefaultPermissions.Activation...)
+		}
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	switch {
+	case wasActivated && !user.Activated:
+		app.audit(r, data.AuditUserDeactivated, user.ID, nil)
+	case !wasActivated && user.Activated:
+		app.audit(r, data.AuditUserReactivated, user.ID, nil)
+	}
+
+	app.requestLogger(r).PrintInfo("user updated", map[string]string{
+		"actor_id":  strconv.FormatInt(actor.ID, 10),
+		"user_id":   strconv.FormatInt(user.ID, 10),
+		"activated": strconv.FormatBool(user.Activated),
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteUserHandler deletes another user's account straight away. As with
+// impersonation, admins can only delete users whose permissions they hold themselves.
+// Everything the user could be logged in with is revoked first, so that their JWTs
+// don't outlive them.
+func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.userForIDParam(w, r)
+	if err != nil {
+		return
+	}
+
+	actor := app.contextGetUser(r)
+
+	if user.ID == actor.ID {
+		v := validator.New()
+		v.AddError("id", "you can't delete yourself")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ok, err := app.actorCovers(r, actor, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err = app.logOutEverywhere(r, user.ID, "deleted_by_admin")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.Delete(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, data.AuditUserDeleted, user.ID, nil)
+
+	app.requestLogger(r).PrintInfo("user deleted", map[string]string{
+		"actor_id": strconv.FormatInt(actor.ID, 10),
+		"user_id":  strconv.FormatInt(user.ID, 10),
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// actorCovers reports whether the actor may act as, or strip the protections from, the
+// user. Admins are never covered, and neither are users with any permission the actor
+// doesn't have, since acting on them would let the actor borrow permissions they don't
+// hold themselves.
+func (app *application) actorCovers(r *http.Request, actor *data.User, userID int64) (bool, error) {
+	permissions, err := app.models.Permissions.GetAllForUser(userID)
+	if err != nil {
+		return false, err
+	}
+
+	actorPermissions, err := app.permissionsForRequest(r, actor)
+	if err != nil {
+		return false, err
+	}
+
+	if permissions.Include("users:admin") || permissions.Include("permissions:admin") {
+		return false, nil
+	}
+
+	return actorPermissions.IncludeAll(permissions), nil
+}
+
+// The createImpersonationTokenHandler gives support staff an authentication token for
+// another user, so they can see the API as that user does. A reason has to be given,
+// and is recorded with who asked for the token. Other admins, and users with any
+// permission the actor doesn't have, can't be impersonated, as that would let the actor
+// borrow permissions they don't have themselves. The token is tied to the
+// impersonation, so it can't be used to create credentials or change the user's
+// security settings, and what's done with it is put down to the actor too.
+func (app *application) createImpersonationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if app.isDelegatedCredential(r) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	user, err := app.userForIDParam(w, r)
+	if err != nil {
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	actor := app.contextGetUser(r)
+
+	impersonation := &data.Impersonation{
+		ActorID: actor.ID,
+		UserID:  user.ID,
+		Reason:  input.Reason,
+		Expiry:  time.Now().Add(impersonationTTL),
+	}
+
+	v := validator.New()
+
+	if data.ValidateImpersonation(v, impersonation); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ok, err := app.actorCovers(r, actor, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err = app.models.Impersonations.Insert(impersonation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.NewForImpersonation(user.ID, impersonation.ID, impersonationTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, data.AuditUserImpersonated, user.ID, map[string]string{
+		"impersonation_id": strconv.FormatInt(impersonation.ID, 10),
+		"reason":           impersonation.Reason,
+	})
+
+	app.requestLogger(r).PrintInfo("user impersonated", map[string]string{
+		"actor_id": strconv.FormatInt(actor.ID, 10),
+		"user_id":  strconv.FormatInt(user.ID, 10),
+		"reason":   impersonation.Reason,
+	})
+
+	env := envelope{"authentication_token": token, "impersonation": impersonation}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestDeleteUser(t *testing.T) {
+	app := newTestApplication(t, newTestDB(t))
+	h := app.routes()
+
+	admin := newTestUser(t, app, "admin@example.com", "users:admin", "movies:read")
+	adminToken := newTestToken(t, app, admin)
+
+	tests := []struct {
+		name        string
+		permissions []string
+		self        bool
+		id          int64
+		wantStatus  int
+	}{
+		{"user with a subset of the admin's permissions", []string{"movies:read"}, false, 0, http.StatusOK},
+		{"user without permissions", nil, false, 0, http.StatusOK},
+		{"another admin", []string{"users:admin"}, false, 0, http.StatusForbidden},
+		{"permissions admin", []string{"permissions:admin"}, false, 0, http.StatusForbidden},
+		{"user with a permission the admin lacks", []string{"movies:write"}, false, 0, http.StatusForbidden},
+		{"the admin themselves", nil, true, 0, http.StatusUnprocessableEntity},
+		{"unknown user", nil, false, 999999, http.StatusNotFound},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := tt.id
+			var userToken string
+
+			switch {
+			case tt.self:
+				id = admin.ID
+			case id == 0:
+				user := newTestUser(t, app, "user"+strconv.Itoa(i)+"@example.com", tt.permissions...)
+				id = user.ID
+				userToken = newTestToken(t, app, user)
+			}
+
+			r := withBearer(httptest.NewRequest(http.MethodDelete, "/v1/users/"+strconv.FormatInt(id, 10), nil), adminToken)
+			res, body := do(t, h, r)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d; want %d (%v)", res.StatusCode, tt.wantStatus, body)
+			}
+
+			if userToken == "" {
+				return
+			}
+
+			// A deleted user's tokens stop working, and one who wasn't deleted can
+			// still use theirs.
+			wantStatus := http.StatusOK
+			if tt.wantStatus == http.StatusOK {
+				wantStatus = http.StatusUnauthorized
+			}
+
+			r = withBearer(httptest.NewRequest(http.MethodGet, "/v1/users/"+strconv.FormatInt(id, 10), nil), userToken)
+			res, _ = do(t, h, r)
+			if res.StatusCode != wantStatus {
+				t.Errorf("user's token afterwards: got status %d; want %d", res.StatusCode, wantStatus)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
@@ -12,9 +13,10 @@ import (
 func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
 
 	var input struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Name            string `json:"name"`
+		Email           string `json:"email"`
+		Password        string `json:"password"`
+		InvitationToken string `json:"invitation_token"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -23,27 +25,76 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	v := validator.New()
+
+	// Invited users can register whatever the registration mode is, and don't need to
+	// activate their account, as the invitation proved their address already.
+	var invitation *data.Invitation
+
+	if input.InvitationToken != "" {
+		invitation, err = app.invitationForRegistration(v, input.InvitationToken, &input.Email)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	} else {
+		app.checkRegistration(v, input.Email)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	user := &data.User{
 		Name:      input.Name,
 		Email:     input.Email,
-		Activated: false,
+		Activated: invitation != nil,
 	}
 
-	// Use the Password.Set() method to generate and store the hashed and plaintext
-	// passwords.
-	err = user.Password.Set(input.Password)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
+	// With -registration-passwordless the password is optional: users who leave it out
+	// log in with magic links, and can set one later through a password reset.
+	if input.Password == "" && app.config.registration.passwordless {
+		err = user.Password.SetRandom()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	} else {
+		// Check the password against the policy before hashing it. Hashing is slow on
+		// purpose, and can't be done at all for passwords longer than the hasher takes.
+		if data.ValidateNewPassword(v, user, input.Password); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		// Use the Password.Set() method to generate and store the hashed and plaintext
+		// passwords.
+		err = user.Password.Set(input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 	}
 
-	v := validator.New()
 	if data.ValidateUser(v, user); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Users.Insert(user)
+	// Give the new user the permissions configured with -registration-permissions, and
+	// invited users the activation permissions and those of their invitation as well.
+	codes := data.DefaultPermissions.Registration
+
+	if invitation != nil {
+		codes = codes.Union(data.DefaultPermissions.Activation).Union(invitation.Permissions)
+		err = app.models.Invitations.Accept(invitation, user, codes...)
+	} else {
+		err = app.models.Users.Insert(user)
+		if err == nil {
+			err = app.models.Permissions.AddForUser(user.ID, codes...)
+		}
+	}
 	if err != nil {
 		switch {
 		// If we get a ErrDuplicateEmail error, use the v.AddError() method to manually
@@ -52,16 +103,22 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		case errors.Is(err, data.ErrDuplicateEmail):
 			v.AddError("email", "a user with this email address already exists")
 			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			v.AddError("invitation_token", "invalid or expired invitation token")
+			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	// Add the "movies:read" permission for the new user
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+	if invitation != nil {
+		app.audit(r, data.AuditUserActivated, user.ID, map[string]string{"invited_by": strconv.FormatInt(invitation.CreatedBy, 10)})
+
+		err = app.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -71,7 +128,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	app.background(func() {
+	app.background(r, func() {
 		// As there are now multiple pieces of data that we want to pass to our email
 		// templates, we create a map to act as a 'holding structure' for the data. This
 		// contains the plaintext version of the activation token for the user, along
@@ -81,9 +138,9 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 			"userID":          user.ID,
 		}
 
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
+		err = app.requestMailer(r).Send(user.Email, "user_welcome.tmpl", data)
 		if err != nil {
-			app.logger.PrintError(err, nil)
+			app.requestLogger(r).PrintError(err, nil)
 		}
 	})
 
@@ -170,12 +227,171 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// Give the user the permissions configured with -activation-permissions.
+	err = app.models.Permissions.AddForUser(user.ID, data.DefaultPermissions.Activation...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, data.AuditUserActivated, user.ID, nil)
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// The resetUserPasswordHandler sets a new password using a token from the password
+// reset email. All of the user's sessions are logged out, since the reset may well be
+// because someone else got hold of the old password.
+func (app *application) resetUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if data.ValidateNewPassword(v, user, input.Password); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.logOutEverywhere(r, user.ID, "password_reset")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.notify(r, user.ID, data.NotificationPasswordChanged)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The changeUserPasswordHandler lets a logged in user change their password. It asks
+// for the current password too, so that a stolen session can't be turned into a
+// stolen account. The user's other sessions are logged out.
+func (app *application) changeUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if app.isDelegatedCredential(r) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	match, err := user.Password.Matches(input.CurrentPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		v.AddError("current_password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if data.ValidateNewPassword(v, user, input.Password); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.logOutElsewhere(r, user.ID, "password_changed")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.notify(r, user.ID, data.NotificationPasswordChanged)
+
+	message := "your password was successfully changed"
+	if app.config.jwt.enabled {
+		message += "; please log in again"
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": message}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 /*
 Hi,
 Thanks for signing up for a Greenlight account. We're excited to have you on board!
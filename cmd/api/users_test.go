@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/data"
+)
+
+func TestPasswordResetLogsOutEverywhere(t *testing.T) {
+	app := newTestApplication(t, newTestDB(t))
+	h := app.routes()
+
+	user := newTestUser(t, app, "alice@example.com", "movies:read")
+	session := newTestToken(t, app, user)
+
+	reset, err := app.models.Tokens.New(user.ID, time.Hour, data.ScopePasswordReset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPut, "/v1/users/password", strings.NewReader(`{"password": "correct horse battery staple", "token": "`+reset.Plaintext+`"}`))
+	res, body := do(t, h, r)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d; want %d (%v)", res.StatusCode, http.StatusOK, body)
+	}
+
+	res, _ = do(t, h, withBearer(httptest.NewRequest(http.MethodGet, "/v1/users/me", nil), session))
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("existing session: got status %d; want %d", res.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestPasswordChangeLogsOutOtherSessions(t *testing.T) {
+	app := newTestApplication(t, newTestDB(t))
+	h := app.routes()
+
+	user := newTestUser(t, app, "alice@example.com", "movies:read")
+	current := newTestToken(t, app, user)
+	other := newTestToken(t, app, user)
+
+	r := withBearer(httptest.NewRequest(http.MethodPut, "/v1/users/me/password", strings.NewReader(`{"current_password": "pa55word1234", "password": "correct horse battery staple"}`)), current)
+	res, body := do(t, h, r)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d; want %d (%v)", res.StatusCode, http.StatusOK, body)
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{"session the password was changed from", current, http.StatusOK},
+		{"other session", other, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, _ := do(t, h, withBearer(httptest.NewRequest(http.MethodGet, "/v1/users/me", nil), tt.token))
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d; want %d", res.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
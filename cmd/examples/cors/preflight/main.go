@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+)
+
+// Define a string constant containing the HTML for the webpage. The JavaScript logs in
+// with a JSON POST to /v1/tokens/authentication, and then uses the token to fetch
+// /v1/users/me. Both requests need a preflight request first: the first because of its
+// Content-Type, and the second because of its Authorization header.
+const html = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+</head>
+<body>
+	<h1>Preflight CORS</h1>
+	<div id="output"></div>
+	<script>
+		document.addEventListener('DOMContentLoaded', function() {
+			fetch("http://localhost:4000/v1/tokens/authentication", {
+				method: "POST",
+				headers: {
+					'Content-Type': 'application/json'
+				},
+				body: JSON.stringify({
+					email: 'alice@example.com',
+					password: 'pa55word'
+				})
+			}).then(function (response) {
+				return response.json();
+			}).then(function (login) {
+				return fetch("http://localhost:4000/v1/users/me", {
+					headers: {
+						'Authorization': 'Bearer ' + login.authentication_token.token
+					}
+				});
+			}).then(function (response) {
+				response.text().then(function (text) {
+					document.getElementById("output").innerHTML = text;
+				});
+			}, function (err) {
+				document.getElementById("output").innerHTML = err;
+			});
+		});
+	</script>
+</body>
+</html>`
+
+func main() {
+	addr := flag.String("addr", ":9000", "Server address")
+	flag.Parse()
+
+	log.Printf("starting server on %s", *addr)
+
+	err := http.ListenAndServe(*addr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(html))
+	}))
+	log.Fatal(err)
+}
@@ -0,0 +1,278 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
+	"github.com/lib/pq"
+)
+
+// APIKeyPrefix marks a bearer credential as a personal API key, which makes keys easy
+// to tell apart from authentication tokens and easy to spot by secret scanners.
+const APIKeyPrefix = "glk_"
+
+// APIKey is a named, long-lived credential which a user creates for scripts and
+// service accounts. Like tokens, only the SHA-256 hash of the key is stored.
+type APIKey struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+	Name       string    `json:"name"`
+	Plaintext  string    `json:"key,omitempty"`
+	Prefix     string    `json:"prefix"`
+	Hash       []byte    `json:"-"`
+	Scopes     []string  `json:"scopes"`
+	AllowedIPs []string  `json:"allowed_ips"`
+	Expiry     time.Time `json:"expiry"`
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// AllowsIP reports whether the key may be used from the given client IP address. Keys
+// without an allowlist can be used from anywhere.
+func (k *APIKey) AllowsIP(ip net.IP) bool {
+	if len(k.AllowedIPs) == 0 {
+		return true
+	}
+
+	if ip == nil {
+		return false
+	}
+
+	for _, allowed := range k.AllowedIPs {
+		if _, network, err := net.ParseCIDR(allowed); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		if allowedIP := net.ParseIP(allowed); allowedIP != nil && allowedIP.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func generateAPIKey(userID int64, name string, expiry time.Time, scopes, allowedIPs []string) (*APIKey, error) {
+	key := &APIKey{
+		UserID:     userID,
+		Name:       name,
+		Scopes:     scopes,
+		AllowedIPs: allowedIPs,
+		Expiry:     expiry,
+	}
+
+	randomBytes := make([]byte, 20)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	key.Plaintext = APIKeyPrefix + secret
+	// The prefix is stored in the clear so that users can identify their keys in
+	// listings without us ever storing enough of the key to use it.
+	key.Prefix = APIKeyPrefix + secret[:6]
+
+	hash := sha256.Sum256([]byte(key.Plaintext))
+	key.Hash = hash[:]
+
+	return key, nil
+}
+
+// New generates a key and inserts it. The plaintext is only available on the returned
+// struct and is never retrievable again.
+func (m APIKeyModel) New(userID int64, name string, expiry time.Time, scopes, allowedIPs []string) (*APIKey, error) {
+	key, err := generateAPIKey(userID, name, expiry, scopes, allowedIPs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(key)
+	return key, err
+}
+
+func (m APIKeyModel) Insert(key *APIKey) error {
+	query := `
+	INSERT INTO api_keys (user_id, name, prefix, hash, scopes, allowed_ips, expiry)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id, created_at`
+
+	args := []any{key.UserID, key.Name, key.Prefix, key.Hash, pq.Array(key.Scopes), pq.Array(key.AllowedIPs), key.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+}
+
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+	SELECT id, user_id, created_at, name, prefix, scopes, allowed_ips, expiry
+	FROM api_keys
+	WHERE user_id = $1
+	ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+
+		err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.CreatedAt,
+			&key.Name,
+			&key.Prefix,
+			pq.Array(&key.Scopes),
+			pq.Array(&key.AllowedIPs),
+			&key.Expiry,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// GetForPlaintext looks up an unexpired key together with the user who owns it.
+func (m APIKeyModel) GetForPlaintext(keyPlaintext string) (*APIKey, *User, error) {
+	keyHash := sha256.Sum256([]byte(keyPlaintext))
+
+	query := `
+	SELECT api_keys.id, api_keys.user_id, api_keys.created_at, api_keys.name, api_keys.prefix,
+		api_keys.scopes, api_keys.allowed_ips, api_keys.expiry,
+		users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+	FROM api_keys
+	INNER JOIN users ON users.id = api_keys.user_id
+	WHERE api_keys.hash = $1
+	AND api_keys.expiry > $2`
+
+	var key APIKey
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, keyHash[:], time.Now()).Scan(
+		&key.ID,
+		&key.UserID,
+		&key.CreatedAt,
+		&key.Name,
+		&key.Prefix,
+		pq.Array(&key.Scopes),
+		pq.Array(&key.AllowedIPs),
+		&key.Expiry,
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	return &key, &user, nil
+}
+
+// DeleteForUser deletes a key, but only if it belongs to the given user.
+func (m APIKeyModel) DeleteForUser(id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM api_keys
+	WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m APIKeyModel) DeleteAllForUser(userID int64) error {
+	query := `
+	DELETE FROM api_keys
+	WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(len(key.Scopes) >= 1, "scopes", "must contain at least 1 permission")
+	v.Check(validator.Unique(key.Scopes), "scopes", "must not contain duplicate values")
+
+	v.Check(len(key.AllowedIPs) <= 20, "allowed_ips", "must not contain more than 20 entries")
+	for _, allowed := range key.AllowedIPs {
+		_, _, err := net.ParseCIDR(allowed)
+		v.Check(err == nil || net.ParseIP(allowed) != nil, "allowed_ips", "must only contain IP addresses or CIDR ranges")
+	}
+
+	v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	v.Check(key.Expiry.Before(time.Now().Add(366*24*time.Hour)), "expiry", "must not be more than a year in the future")
+}
+
+func ValidateAPIKeyPlaintext(v *validator.Validator, keyPlaintext string) {
+	v.Check(strings.HasPrefix(keyPlaintext, APIKeyPrefix), "key", "must be an API key")
+	v.Check(len(keyPlaintext) == len(APIKeyPrefix)+32, "key", "must be 36 bytes long")
+}
@@ -0,0 +1,34 @@
+package data
+
+import (
+	"net"
+	"testing"
+)
+
+func TestAPIKeyAllowsIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		allowedIPs []string
+		ip         net.IP
+		want       bool
+	}{
+		{"no allowlist", nil, net.ParseIP("192.0.2.1"), true},
+		{"no allowlist or IP", nil, nil, true},
+		{"listed address", []string{"192.0.2.1"}, net.ParseIP("192.0.2.1"), true},
+		{"unlisted address", []string{"192.0.2.1"}, net.ParseIP("192.0.2.2"), false},
+		{"address in range", []string{"10.0.0.0/8"}, net.ParseIP("10.1.2.3"), true},
+		{"address outside range", []string{"10.0.0.0/8"}, net.ParseIP("192.0.2.1"), false},
+		{"IPv6 range", []string{"2001:db8::/32"}, net.ParseIP("2001:db8::1"), true},
+		{"second entry", []string{"10.0.0.0/8", "192.0.2.1"}, net.ParseIP("192.0.2.1"), true},
+		{"allowlist without an IP", []string{"10.0.0.0/8"}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := &APIKey{AllowedIPs: tt.allowedIPs}
+			if got := key.AllowsIP(tt.ip); got != tt.want {
+				t.Errorf("got %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
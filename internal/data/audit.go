@@ -0,0 +1,178 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Audit actions.
+const (
+	AuditLoginSucceeded    = "login.succeeded"
+	AuditLoginFailed       = "login.failed"
+	AuditTokenCreated      = "token.created"
+	AuditTokenRevoked      = "token.revoked"
+	AuditUserActivated     = "user.activated"
+	AuditPermissionGranted = "permission.granted"
+	AuditPermissionRevoked = "permission.revoked"
+	AuditRoleAssigned      = "role.assigned"
+	AuditRoleUnassigned    = "role.unassigned"
+	AuditRoleCreated       = "role.created"
+	AuditRoleUpdated       = "role.updated"
+	AuditRoleDeleted       = "role.deleted"
+	AuditUserDeactivated   = "user.deactivated"
+	AuditUserReactivated   = "user.reactivated"
+	AuditAccessDenied      = "access.denied"
+	AuditUserImpersonated  = "user.impersonated"
+	AuditUserDeleted       = "user.deleted"
+	AuditTOTPReset         = "totp.reset"
+)
+
+// AuditEvent records something security relevant: who did it (the actor, if they were
+// authenticated), who it was done to (the subject), and where the request came from.
+type AuditEvent struct {
+	ID        int64             `json:"id"`
+	CreatedAt time.Time         `json:"created_at"`
+	Action    string            `json:"action"`
+	ActorID   *int64            `json:"actor_id"`
+	SubjectID *int64            `json:"subject_id"`
+	IP        string            `json:"ip"`
+	UserAgent string            `json:"user_agent"`
+	RequestID string            `json:"request_id"`
+	Details   map[string]string `json:"details"`
+}
+
+// AuditFilters narrows down a list of audit events. Zero values match everything.
+type AuditFilters struct {
+	Actions   []string
+	ActorID   int64
+	SubjectID int64
+	Since     time.Time
+	Until     time.Time
+	Filters
+}
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+func (m AuditModel) Insert(event *AuditEvent) error {
+	details, err := json.Marshal(event.Details)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO audit_events (action, actor_id, subject_id, ip, user_agent, request_id, details)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id, created_at`
+
+	args := []any{event.Action, event.ActorID, event.SubjectID, event.IP, event.UserAgent, event.RequestID, details}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+}
+
+func (m AuditModel) GetAll(filters AuditFilters) ([]*AuditEvent, Metadata, error) {
+	if filters.Actions == nil {
+		filters.Actions = []string{}
+	}
+
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, action, actor_id, subject_id, ip, user_agent, request_id, details
+	FROM audit_events
+	WHERE (action = ANY($1) OR $1 = '{}')
+	AND (actor_id = $2 OR $2 = 0)
+	AND (subject_id = $3 OR $3 = 0)
+	AND (created_at >= $4 OR $4 IS NULL)
+	AND (created_at < $5 OR $5 IS NULL)
+	ORDER BY %s %s, id DESC
+	LIMIT $6 OFFSET $7`, filters.sortColumn(), filters.sortDirection())
+
+	// Zero times are passed as NULL, which matches everything.
+	var since, until *time.Time
+	if !filters.Since.IsZero() {
+		since = &filters.Since
+	}
+	if !filters.Until.IsZero() {
+		until = &filters.Until
+	}
+
+	args := []any{
+		pq.Array(filters.Actions),
+		filters.ActorID,
+		filters.SubjectID,
+		since,
+		until,
+		filters.limit(),
+		filters.offset(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+		var event AuditEvent
+		var details []byte
+
+		err := rows.Scan(
+			&totalRecords,
+			&event.ID,
+			&event.CreatedAt,
+			&event.Action,
+			&event.ActorID,
+			&event.SubjectID,
+			&event.IP,
+			&event.UserAgent,
+			&event.RequestID,
+			&details,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		err = json.Unmarshal(details, &event.Details)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return events, metadata, nil
+}
+
+// DeleteOlderThan deletes the events from before the given time, which is the only way
+// they are ever removed.
+func (m AuditModel) DeleteOlderThan(before time.Time) error {
+	query := `
+	DELETE FROM audit_events
+	WHERE created_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, before)
+	return err
+}
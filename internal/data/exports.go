@@ -0,0 +1,103 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Export is an archive of everything stored about a user, built when they ask for a
+// copy of their data. It can be downloaded with its token until it expires.
+type Export struct {
+	ID        int64
+	CreatedAt time.Time
+	Plaintext string
+	Hash      []byte
+	UserID    int64
+	Archive   []byte
+	Expiry    time.Time
+}
+
+type ExportModel struct {
+	DB *sql.DB
+}
+
+func (m ExportModel) New(userID int64, archive []byte, ttl time.Duration) (*Export, error) {
+	token, err := generateToken(userID, ttl, "")
+	if err != nil {
+		return nil, err
+	}
+
+	export := &Export{
+		Plaintext: token.Plaintext,
+		Hash:      token.Hash,
+		UserID:    userID,
+		Archive:   archive,
+		Expiry:    token.Expiry,
+	}
+
+	query := `
+	INSERT INTO data_exports (hash, user_id, archive, expiry)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at`
+
+	args := []any{export.Hash, export.UserID, export.Archive, export.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&export.ID, &export.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+func (m ExportModel) GetForToken(tokenPlaintext string) (*Export, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+	SELECT id, created_at, hash, user_id, archive, expiry
+	FROM data_exports
+	WHERE hash = $1
+	AND expiry > $2`
+
+	var export Export
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], time.Now()).Scan(
+		&export.ID,
+		&export.CreatedAt,
+		&export.Hash,
+		&export.UserID,
+		&export.Archive,
+		&export.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &export, nil
+}
+
+func (m ExportModel) DeleteExpired() error {
+	query := `
+	DELETE FROM data_exports
+	WHERE expiry <= $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now())
+	return err
+}
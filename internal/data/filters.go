@@ -4,6 +4,9 @@
 package data
 
 import (
+	"math"
+	"strings"
+
 	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
 )
 
@@ -14,7 +17,7 @@ type Filters struct {
 	SortSafelist []string
 }
 
-/* // Sorting Lists
+// Sorting Lists
 // Check that the client-provided Sort field matches one of the entries in our safelist
 // and if it does, extract the column name from the Sort field by stripping the leading
 // hyphen character (if one exists).
@@ -48,10 +51,10 @@ func (f Filters) offset() int {
 
 // Pagination Metadata
 type Metadata struct {
-	CurrentPage int `json:"current_page,omitempty"`
-	PageSize int `json:"page_size,omitempty"`
-	FirstPage int `json:"first_page,omitempty"`
-	LastPage int `json:"last_page,omitempty"`
+	CurrentPage  int `json:"current_page,omitempty"`
+	PageSize     int `json:"page_size,omitempty"`
+	FirstPage    int `json:"first_page,omitempty"`
+	LastPage     int `json:"last_page,omitempty"`
 	TotalRecords int `json:"total_records,omitempty"`
 }
 
@@ -66,16 +69,14 @@ func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 		return Metadata{}
 	}
 	return Metadata{
-		CurrentPage: page,
-		PageSize: pageSize,
-		FirstPage: 1,
-		LastPage: int(math.Ceil(float64(totalRecords) / float64(pageSize))),
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     int(math.Ceil(float64(totalRecords) / float64(pageSize))),
 		TotalRecords: totalRecords,
 	}
 }
 
-*/
-
 // Additional sanity checks on the query string values provided by the client
 func ValidateFilters(v *validator.Validator, f Filters) {
 	// Check that the page and page_size parameters contain sensible values.
@@ -0,0 +1,161 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrDuplicateIdentity = errors.New("duplicate identity")
+)
+
+// Identity links a user to their account at an external OpenID Connect provider. The
+// (issuer, subject) pair is the only stable identifier a provider gives us; the email
+// is kept for reference only.
+type Identity struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+}
+
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+func (m IdentityModel) Insert(identity *Identity) error {
+	query := `
+	INSERT INTO user_identities (user_id, issuer, subject, email)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at`
+
+	args := []any{identity.UserID, identity.Issuer, identity.Subject, identity.Email}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&identity.ID, &identity.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "user_identities_issuer_subject_key"`:
+			return ErrDuplicateIdentity
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetUser retrieves the user linked to an external identity.
+func (m IdentityModel) GetUser(issuer, subject string) (*User, error) {
+	query := `
+	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+	FROM users
+	INNER JOIN user_identities ON user_identities.user_id = users.id
+	WHERE user_identities.issuer = $1
+	AND user_identities.subject = $2`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, issuer, subject).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// OIDCLogin holds the per-login secrets for an in-progress OpenID Connect login: the
+// state which ties the provider's callback to the login, the nonce which ties the ID
+// token to it, and the PKCE code verifier.
+type OIDCLogin struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+	Expiry       time.Time
+}
+
+type OIDCLoginModel struct {
+	DB *sql.DB
+}
+
+func (m OIDCLoginModel) New(ttl time.Duration) (*OIDCLogin, error) {
+	login := &OIDCLogin{Expiry: time.Now().Add(ttl)}
+
+	var err error
+
+	for _, dst := range []*string{&login.State, &login.Nonce, &login.CodeVerifier} {
+		*dst, err = randomString(32)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stateHash := sha256.Sum256([]byte(login.State))
+
+	query := `
+	INSERT INTO oidc_logins (state_hash, nonce, code_verifier, expiry)
+	VALUES ($1, $2, $3, $4)`
+
+	args := []any{stateHash[:], login.Nonce, login.CodeVerifier, login.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return login, nil
+}
+
+// Consume deletes and returns the unexpired login for the given state, so that each
+// provider callback can only be used once.
+func (m OIDCLoginModel) Consume(state string) (*OIDCLogin, error) {
+	stateHash := sha256.Sum256([]byte(state))
+
+	query := `
+	DELETE FROM oidc_logins
+	WHERE state_hash = $1 AND expiry > $2
+	RETURNING nonce, code_verifier, expiry`
+
+	login := OIDCLogin{State: state}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, stateHash[:], time.Now()).Scan(&login.Nonce, &login.CodeVerifier, &login.Expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &login, nil
+}
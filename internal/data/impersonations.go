@@ -0,0 +1,44 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
+)
+
+// Impersonation records that a member of support staff was given a token to act as a
+// user, and why.
+type Impersonation struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	ActorID   int64     `json:"actor_id"`
+	UserID    int64     `json:"user_id"`
+	Reason    string    `json:"reason"`
+	Expiry    time.Time `json:"expiry"`
+}
+
+type ImpersonationModel struct {
+	DB *sql.DB
+}
+
+func (m ImpersonationModel) Insert(impersonation *Impersonation) error {
+	query := `
+	INSERT INTO impersonations (actor_id, user_id, reason, expiry)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at`
+
+	args := []any{impersonation.ActorID, impersonation.UserID, impersonation.Reason, impersonation.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&impersonation.ID, &impersonation.CreatedAt)
+}
+
+func ValidateImpersonation(v *validator.Validator, impersonation *Impersonation) {
+	v.Check(impersonation.Reason != "", "reason", "must be provided")
+	v.Check(len(impersonation.Reason) <= 1000, "reason", "must not be more than 1000 bytes long")
+	v.Check(impersonation.ActorID != impersonation.UserID, "user", "you can't impersonate yourself")
+}
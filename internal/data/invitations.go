@@ -0,0 +1,143 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
+	"github.com/lib/pq"
+)
+
+// Invitation lets someone register when registration is by invitation only. The
+// account is created with the invited email address and activated straight away, and
+// gets the invitation's permissions on top of the usual ones.
+type Invitation struct {
+	ID          int64      `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Plaintext   string     `json:"-"`
+	Hash        []byte     `json:"-"`
+	Email       string     `json:"email"`
+	Permissions []string   `json:"permissions"`
+	CreatedBy   int64      `json:"created_by"`
+	Expiry      time.Time  `json:"expiry"`
+	AcceptedAt  *time.Time `json:"accepted_at"`
+}
+
+type InvitationModel struct {
+	DB *sql.DB
+}
+
+// New creates an invitation with a random token, which is in the same format as the
+// tokens in the tokens table.
+func (m InvitationModel) New(invitation *Invitation, ttl time.Duration) error {
+	token, err := generateToken(0, ttl, "")
+	if err != nil {
+		return err
+	}
+
+	invitation.Plaintext = token.Plaintext
+	invitation.Hash = token.Hash
+	invitation.Expiry = token.Expiry
+
+	query := `
+	INSERT INTO invitations (hash, email, permissions, created_by, expiry)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at`
+
+	args := []any{invitation.Hash, invitation.Email, pq.Array(invitation.Permissions), invitation.CreatedBy, invitation.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&invitation.ID, &invitation.CreatedAt)
+}
+
+// GetForToken retrieves an invitation which hasn't expired or been accepted yet.
+func (m InvitationModel) GetForToken(tokenPlaintext string) (*Invitation, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+	SELECT id, created_at, hash, email, permissions, coalesce(created_by, 0), expiry
+	FROM invitations
+	WHERE hash = $1
+	AND accepted_at IS NULL
+	AND expiry > $2`
+
+	var invitation Invitation
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], time.Now()).Scan(
+		&invitation.ID,
+		&invitation.CreatedAt,
+		&invitation.Hash,
+		&invitation.Email,
+		pq.Array(&invitation.Permissions),
+		&invitation.CreatedBy,
+		&invitation.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &invitation, nil
+}
+
+// Accept creates the invited user with the given permissions and marks the invitation
+// as used, all in one transaction, so that the invitation is only used up if the user
+// is created. It returns ErrDuplicateEmail if the address is taken, and ErrEditConflict
+// if the invitation was used already, by a request which got in first.
+func (m InvitationModel) Accept(invitation *Invitation, user *User, codes ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertUser(ctx, tx, user)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	UPDATE invitations
+	SET accepted_at = NOW()
+	WHERE id = $1 AND accepted_at IS NULL
+	RETURNING accepted_at`
+
+	err = tx.QueryRowContext(ctx, query, invitation.ID).Scan(&invitation.AcceptedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = addPermissionsForUser(ctx, tx, user.ID, codes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func ValidateInvitation(v *validator.Validator, invitation *Invitation) {
+	ValidateEmail(v, invitation.Email)
+
+	v.Check(invitation.Permissions != nil, "permissions", "must be provided")
+	v.Check(validator.Unique(invitation.Permissions), "permissions", "must not contain duplicate values")
+}
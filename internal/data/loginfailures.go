@@ -0,0 +1,113 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// LoginFailures counts the failed logins for a key, which is either an account
+// ("email:...") or a client IP address ("ip:..."). No attempts are allowed for the key
+// until LockedUntil has passed.
+type LoginFailures struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
+
+type LoginFailureModel struct {
+	DB *sql.DB
+}
+
+// Get returns the failures recorded for each of the given keys that has any.
+func (m LoginFailureModel) Get(keys ...string) ([]*LoginFailures, error) {
+	query := `
+	SELECT key, failures, last_failure_at, locked_until
+	FROM login_failures
+	WHERE key = ANY($1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(keys))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	all := []*LoginFailures{}
+
+	for rows.Next() {
+		var failures LoginFailures
+
+		err := rows.Scan(&failures.Key, &failures.Failures, &failures.LastFailureAt, &failures.LockedUntil)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, &failures)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return all, nil
+}
+
+// RecordFailure adds a failed login for the key and returns the new count. Failures
+// older than window are forgotten, so the count starts again at 1.
+func (m LoginFailureModel) RecordFailure(key string, window time.Duration) (int, error) {
+	query := `
+	INSERT INTO login_failures (key, failures, last_failure_at)
+	VALUES ($1, 1, NOW())
+	ON CONFLICT (key) DO UPDATE SET
+		failures = CASE
+			WHEN login_failures.last_failure_at < NOW() - make_interval(secs => $2) THEN 1
+			ELSE login_failures.failures + 1
+		END,
+		last_failure_at = NOW()
+	RETURNING failures`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var failures int
+
+	err := m.DB.QueryRowContext(ctx, query, key, window.Seconds()).Scan(&failures)
+	if err != nil {
+		return 0, err
+	}
+
+	return failures, nil
+}
+
+// Lock stops logins for the key until the given time.
+func (m LoginFailureModel) Lock(key string, until time.Time) error {
+	query := `
+	UPDATE login_failures
+	SET locked_until = $2
+	WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key, until)
+	return err
+}
+
+// Clear forgets the failures for the key, which also lifts any lockout.
+func (m LoginFailureModel) Clear(key string) error {
+	query := `
+	DELETE FROM login_failures
+	WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key)
+	return err
+}
@@ -3,6 +3,7 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 )
@@ -12,18 +13,61 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
+// querier is what *sql.DB and *sql.Tx have in common, so that a query can be shared
+// between a model method which runs it on its own and one which runs it as part of a
+// bigger transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 type Models struct {
-	Movies      MovieModel
-	Users       UserModel
-	Tokens      TokenModel
-	Permissions PermissionModel
+	Movies            MovieModel
+	Users             UserModel
+	Tokens            TokenModel
+	Permissions       PermissionModel
+	Revocations       RevocationModel
+	APIKeys           APIKeyModel
+	OAuthClients      OAuthClientModel
+	OAuthCodes        OAuthCodeModel
+	OAuthConsents     OAuthConsentModel
+	Identities        IdentityModel
+	OIDCLogins        OIDCLoginModel
+	TOTP              TOTPModel
+	LoginFailures     LoginFailureModel
+	PermissionChanges PermissionChangeModel
+	Roles             RoleModel
+	Impersonations    ImpersonationModel
+	Invitations       InvitationModel
+	Exports           ExportModel
+	Audit             AuditModel
+	Notifications     NotificationModel
+	RateLimits        RateLimitModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
+		Movies:            MovieModel{DB: db},
+		Permissions:       PermissionModel{DB: db},
+		Revocations:       RevocationModel{DB: db},
+		APIKeys:           APIKeyModel{DB: db},
+		OAuthClients:      OAuthClientModel{DB: db},
+		OAuthCodes:        OAuthCodeModel{DB: db},
+		OAuthConsents:     OAuthConsentModel{DB: db},
+		Identities:        IdentityModel{DB: db},
+		OIDCLogins:        OIDCLoginModel{DB: db},
+		TOTP:              TOTPModel{DB: db},
+		LoginFailures:     LoginFailureModel{DB: db},
+		PermissionChanges: PermissionChangeModel{DB: db},
+		Roles:             RoleModel{DB: db},
+		Impersonations:    ImpersonationModel{DB: db},
+		Invitations:       InvitationModel{DB: db},
+		Exports:           ExportModel{DB: db},
+		Audit:             AuditModel{DB: db},
+		Notifications:     NotificationModel{DB: db},
+		RateLimits:        RateLimitModel{DB: db},
+		Users:             UserModel{DB: db},
+		Tokens:            TokenModel{DB: db},
 	}
 }
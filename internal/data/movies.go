@@ -19,6 +19,7 @@ type Movie struct {
 	Runtime   Runtime   `json:"runtime,omitempty,string"` // advanced json customization
 	Genres    []string  `json:"genres"`
 	Version   int32     `json:"version,omitempty,string"` // the string directive only work on struct fields which have int*/uint*/float*/bool types
+	CreatedBy *int64    `json:"created_by,omitempty"`     // nil for movies added before movies had owners
 }
 
 // the fields and types in the movie struct above is analogous to the fields and types of the database migrations table
@@ -30,13 +31,13 @@ type MovieModel struct {
 
 func (m MovieModel) Insert(movie *Movie) error {
 	query := `
-	INSERT INTO movies (title, year, runtime, genres)
-	VALUES ($1, $2, $3, $4)
+	INSERT INTO movies (title, year, runtime, genres, created_by)
+	VALUES ($1, $2, $3, $4, $5)
 	RETURNING id, created_at, version`
 
 	// You can also use the pq.Array() adapter function in the same way with []bool,
 	// []byte, []int32, []int64, []float32 and []float64 slices
-	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.CreatedBy}
 
 	// Create a context with a 3-second timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -56,7 +57,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	}
 
 	query := `
-	SELECT id, created_at, title, year, runtime, genres, version
+	SELECT id, created_at, title, year, runtime, genres, version, created_by
 	FROM movies
 	WHERE id = $1`
 
@@ -74,6 +75,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
 		&movie.Version,
+		&movie.CreatedBy,
 	)
 
 	if err != nil {
@@ -154,7 +156,9 @@ func (m MovieModel) Delete(id int64) error {
 	return nil
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, error) { //([]*Movie, Metadata, error)
+// GetAll lists movies. If userID isn't 0, only movies the user created or which have
+// been shared with them are listed.
+func (m MovieModel) GetAll(title string, genres []string, userID int64, filters Filters) ([]*Movie, error) { //([]*Movie, Metadata, error)
 	/*
 		// Construct the SQL query to retrieve all movie records
 		query := `
@@ -257,10 +261,14 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	// PostgreSQL’s full textsearch functionality, to use by adapting it to
 	// support partial matches, rather than requiring a match on the full title.
 	query := `
-	SELECT id, created_at, title, year, runtime, genres, version
+	SELECT id, created_at, title, year, runtime, genres, version, created_by
 	FROM movies
 	WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
 	AND (genres @> $2 OR $2 = '{}')
+	AND ($3 = 0 OR created_by = $3 OR EXISTS (
+		SELECT 1 FROM movies_collaborators
+		WHERE movies_collaborators.movie_id = movies.id AND movies_collaborators.user_id = $3
+	))
 	ORDER BY id`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -268,7 +276,7 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 
 	// rows, err := m.DB.QueryContext(ctx, query)
 	// rows, err := m.DB.QueryContext(ctx, query, title, pq.Array(genres))
-	rows, err := m.DB.QueryContext(ctx, query, title, pq.Array(genres))
+	rows, err := m.DB.QueryContext(ctx, query, title, pq.Array(genres), userID)
 	if err != nil {
 		return nil, err
 	}
@@ -291,6 +299,7 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
 			&movie.Version,
+			&movie.CreatedBy,
 		)
 		if err != nil {
 			return nil, err
@@ -310,6 +319,62 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	return movies, nil
 }
 
+// IsCollaborator reports whether the movie has been shared with the user.
+func (m MovieModel) IsCollaborator(movieID, userID int64) (bool, error) {
+	query := `
+	SELECT EXISTS (
+		SELECT 1 FROM movies_collaborators
+		WHERE movie_id = $1 AND user_id = $2
+	)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, userID).Scan(&exists)
+	return exists, err
+}
+
+// AddCollaborator shares the movie with the user. Sharing it again does nothing.
+func (m MovieModel) AddCollaborator(movieID, userID int64) error {
+	query := `
+	INSERT INTO movies_collaborators (movie_id, user_id)
+	VALUES ($1, $2)
+	ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, movieID, userID)
+	return err
+}
+
+func (m MovieModel) RemoveCollaborator(movieID, userID int64) error {
+	query := `
+	DELETE FROM movies_collaborators
+	WHERE movie_id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, movieID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
 func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(movie.Title != "", "title", "must be provided")
 	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
@@ -0,0 +1,194 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
+	"github.com/lib/pq"
+)
+
+// Kinds of security notification. Each one is sent with the mailer template of the same
+// name.
+const (
+	NotificationNewLogin        = "new_login"
+	NotificationPasswordChanged = "password_changed"
+	NotificationTOTPEnabled     = "totp_enabled"
+	NotificationTOTPDisabled    = "totp_disabled"
+)
+
+// OptionalNotifications are the notifications users can turn off. The others are about
+// changes which could lock the user out of their account, so they are always sent; that
+// includes totp_enabled, as someone else's authenticator is just such a change.
+var OptionalNotifications = []string{NotificationNewLogin}
+
+func ValidateNotificationOptOuts(v *validator.Validator, kinds []string) {
+	v.Check(kinds != nil, "opt_out", "must be provided")
+	v.Check(validator.Unique(kinds), "opt_out", "must not contain duplicate values")
+
+	for _, kind := range kinds {
+		v.Check(validator.PermittedValue(kind, OptionalNotifications...), "opt_out", "must only contain notifications which can be turned off")
+	}
+}
+
+type NotificationModel struct {
+	DB *sql.DB
+}
+
+// GetOptOuts returns the notifications the user has turned off.
+func (m NotificationModel) GetOptOuts(userID int64) ([]string, error) {
+	query := `
+	SELECT kind
+	FROM notification_opt_outs
+	WHERE user_id = $1
+	ORDER BY kind`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	kinds := []string{}
+
+	for rows.Next() {
+		var kind string
+
+		err := rows.Scan(&kind)
+		if err != nil {
+			return nil, err
+		}
+
+		kinds = append(kinds, kind)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return kinds, nil
+}
+
+// SetOptOuts replaces the notifications the user has turned off.
+func (m NotificationModel) SetOptOuts(userID int64, kinds []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM notification_opt_outs WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO notification_opt_outs (user_id, kind)
+	SELECT $1, unnest($2::text[])`
+
+	_, err = tx.ExecContext(ctx, query, userID, pq.Array(kinds))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// HasOptedOut reports whether the user has turned the notification off.
+func (m NotificationModel) HasOptedOut(userID int64, kind string) (bool, error) {
+	query := `
+	SELECT EXISTS (
+		SELECT 1 FROM notification_opt_outs WHERE user_id = $1 AND kind = $2
+	)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var optedOut bool
+
+	err := m.DB.QueryRowContext(ctx, query, userID, kind).Scan(&optedOut)
+	return optedOut, err
+}
+
+// RecordLogin remembers that the user logged in from the IP address with the user
+// agent, and reports whether either of them is new. A user's first recorded login isn't
+// new, since there is nothing to compare it with.
+func (m NotificationModel) RecordLogin(userID int64, ip, userAgent string) (newIP, newDevice bool, err error) {
+	query := `
+	SELECT count(*) > 0, coalesce(bool_or(ip = $2), false), coalesce(bool_or(user_agent = $3), false)
+	FROM user_logins
+	WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var seenAny, seenIP, seenDevice bool
+
+	err = m.DB.QueryRowContext(ctx, query, userID, ip, userAgent).Scan(&seenAny, &seenIP, &seenDevice)
+	if err != nil {
+		return false, false, err
+	}
+
+	query = `
+	INSERT INTO user_logins (user_id, ip, user_agent)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (user_id, ip, user_agent) DO UPDATE SET last_seen_at = NOW()`
+
+	_, err = m.DB.ExecContext(ctx, query, userID, ip, userAgent)
+	if err != nil {
+		return false, false, err
+	}
+
+	return seenAny && !seenIP, seenAny && !seenDevice, nil
+}
+
+// Login is an IP address and user agent a user has logged in from.
+type Login struct {
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+}
+
+func (m NotificationModel) GetAllLogins(userID int64) ([]*Login, error) {
+	query := `
+	SELECT ip, user_agent, first_seen_at, last_seen_at
+	FROM user_logins
+	WHERE user_id = $1
+	ORDER BY last_seen_at DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logins := []*Login{}
+
+	for rows.Next() {
+		var login Login
+
+		err := rows.Scan(&login.IP, &login.UserAgent, &login.FirstSeenAt, &login.LastSeenAt)
+		if err != nil {
+			return nil, err
+		}
+
+		logins = append(logins, &login)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return logins, nil
+}
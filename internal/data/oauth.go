@@ -0,0 +1,379 @@
+// OAuth 2.0 Authorization Server: registered clients, authorization codes and the
+// consent users have given. Access tokens themselves live in the tokens table.
+
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
+	"github.com/lib/pq"
+)
+
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantClientCredentials = "client_credentials"
+)
+
+// OAuthClient is a third-party application registered by a user. Confidential clients
+// have a secret; public clients (e.g. single page or mobile apps) rely on PKCE alone.
+// Client credentials tokens act on behalf of the user who registered the client.
+type OAuthClient struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	ClientID     string    `json:"client_id"`
+	Secret       string    `json:"client_secret,omitempty"`
+	SecretHash   []byte    `json:"-"`
+	UserID       int64     `json:"-"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	GrantTypes   []string  `json:"grant_types"`
+}
+
+type OAuthClientModel struct {
+	DB *sql.DB
+}
+
+// IsConfidential reports whether the client was issued a secret.
+func (c *OAuthClient) IsConfidential() bool {
+	return c.SecretHash != nil
+}
+
+// SecretMatches compares a client secret against the stored hash in constant time.
+func (c *OAuthClient) SecretMatches(secret string) bool {
+	if !c.IsConfidential() {
+		return false
+	}
+	hash := sha256.Sum256([]byte(secret))
+	return subtle.ConstantTimeCompare(hash[:], c.SecretHash) == 1
+}
+
+// AllowsGrant reports whether the client was registered for the given grant type.
+func (c *OAuthClient) AllowsGrant(grantType string) bool {
+	return validator.PermittedValue(grantType, c.GrantTypes...)
+}
+
+// AllowsRedirectURI reports whether redirectURI exactly matches one registered for the
+// client. Prefix or pattern matching would open the door to open redirects.
+func (c *OAuthClient) AllowsRedirectURI(redirectURI string) bool {
+	return validator.PermittedValue(redirectURI, c.RedirectURIs...)
+}
+
+func randomString(n int) (string, error) {
+	randomBytes := make([]byte, n)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
+
+// GenerateCredentials sets a new client ID and, for confidential clients, a new
+// secret. The plaintext secret is only ever available on the struct it was set on.
+func (c *OAuthClient) GenerateCredentials(confidential bool) error {
+	clientID, err := randomString(10)
+	if err != nil {
+		return err
+	}
+	c.ClientID = "glc_" + clientID
+
+	if confidential {
+		secret, err := randomString(32)
+		if err != nil {
+			return err
+		}
+
+		hash := sha256.Sum256([]byte(secret))
+		c.Secret = secret
+		c.SecretHash = hash[:]
+	}
+
+	return nil
+}
+
+func (m OAuthClientModel) Insert(client *OAuthClient) error {
+	query := `
+	INSERT INTO oauth_clients (client_id, secret_hash, user_id, name, redirect_uris, scopes, grant_types)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id, created_at`
+
+	args := []any{
+		client.ClientID,
+		client.SecretHash,
+		client.UserID,
+		client.Name,
+		pq.Array(client.RedirectURIs),
+		pq.Array(client.Scopes),
+		pq.Array(client.GrantTypes),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&client.ID, &client.CreatedAt)
+}
+
+func (m OAuthClientModel) GetByClientID(clientID string) (*OAuthClient, error) {
+	query := `
+	SELECT id, created_at, client_id, secret_hash, user_id, name, redirect_uris, scopes, grant_types
+	FROM oauth_clients
+	WHERE client_id = $1`
+
+	var client OAuthClient
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, clientID).Scan(
+		&client.ID,
+		&client.CreatedAt,
+		&client.ClientID,
+		&client.SecretHash,
+		&client.UserID,
+		&client.Name,
+		pq.Array(&client.RedirectURIs),
+		pq.Array(&client.Scopes),
+		pq.Array(&client.GrantTypes),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &client, nil
+}
+
+func (m OAuthClientModel) GetAllForUser(userID int64) ([]*OAuthClient, error) {
+	query := `
+	SELECT id, created_at, client_id, secret_hash, user_id, name, redirect_uris, scopes, grant_types
+	FROM oauth_clients
+	WHERE user_id = $1
+	ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []*OAuthClient{}
+
+	for rows.Next() {
+		var client OAuthClient
+
+		err := rows.Scan(
+			&client.ID,
+			&client.CreatedAt,
+			&client.ClientID,
+			&client.SecretHash,
+			&client.UserID,
+			&client.Name,
+			pq.Array(&client.RedirectURIs),
+			pq.Array(&client.Scopes),
+			pq.Array(&client.GrantTypes),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		clients = append(clients, &client)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+// DeleteForUser deletes a client registered by the given user. Its codes, consents
+// and access tokens go with it through the ON DELETE CASCADE foreign keys.
+func (m OAuthClientModel) DeleteForUser(id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM oauth_clients
+	WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func ValidateOAuthClient(v *validator.Validator, client *OAuthClient) {
+	v.Check(client.Name != "", "name", "must be provided")
+	v.Check(len(client.Name) <= 200, "name", "must not be more than 200 bytes long")
+
+	v.Check(len(client.Scopes) >= 1, "scopes", "must contain at least 1 permission")
+	v.Check(validator.Unique(client.Scopes), "scopes", "must not contain duplicate values")
+
+	v.Check(len(client.GrantTypes) >= 1, "grant_types", "must contain at least 1 grant type")
+	v.Check(validator.Unique(client.GrantTypes), "grant_types", "must not contain duplicate values")
+	for _, grantType := range client.GrantTypes {
+		v.Check(validator.PermittedValue(grantType, GrantAuthorizationCode, GrantClientCredentials), "grant_types", "must only contain authorization_code or client_credentials")
+	}
+
+	if client.AllowsGrant(GrantAuthorizationCode) {
+		v.Check(len(client.RedirectURIs) >= 1, "redirect_uris", "must contain at least 1 URI for the authorization_code grant")
+	}
+	if client.AllowsGrant(GrantClientCredentials) {
+		v.Check(client.IsConfidential(), "confidential", "must be true for the client_credentials grant")
+	}
+
+	v.Check(len(client.RedirectURIs) <= 10, "redirect_uris", "must not contain more than 10 URIs")
+	for _, redirectURI := range client.RedirectURIs {
+		u, err := url.Parse(redirectURI)
+		v.Check(err == nil && u.IsAbs() && u.Fragment == "", "redirect_uris", "must only contain absolute URIs without a fragment")
+	}
+}
+
+// OAuthCode is a short-lived, single-use authorization code. The PKCE code challenge
+// is stored with it and checked against the verifier when the code is exchanged.
+type OAuthCode struct {
+	Plaintext     string
+	Hash          []byte
+	ClientID      int64
+	UserID        int64
+	RedirectURI   string
+	Scopes        []string
+	CodeChallenge string
+	Expiry        time.Time
+}
+
+type OAuthCodeModel struct {
+	DB *sql.DB
+}
+
+func (m OAuthCodeModel) New(code *OAuthCode, ttl time.Duration) error {
+	plaintext, err := randomString(20)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.Sum256([]byte(plaintext))
+
+	code.Plaintext = plaintext
+	code.Hash = hash[:]
+	code.Expiry = time.Now().Add(ttl)
+
+	query := `
+	INSERT INTO oauth_authorization_codes (hash, client_id, user_id, redirect_uri, scopes, code_challenge, expiry)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	args := []any{code.Hash, code.ClientID, code.UserID, code.RedirectURI, pq.Array(code.Scopes), code.CodeChallenge, code.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Consume deletes an unexpired code and returns it, so each code can be exchanged at
+// most once even if two requests race each other.
+func (m OAuthCodeModel) Consume(codePlaintext string) (*OAuthCode, error) {
+	hash := sha256.Sum256([]byte(codePlaintext))
+
+	query := `
+	DELETE FROM oauth_authorization_codes
+	WHERE hash = $1 AND expiry > $2
+	RETURNING client_id, user_id, redirect_uri, scopes, code_challenge, expiry`
+
+	code := OAuthCode{Hash: hash[:]}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, hash[:], time.Now()).Scan(
+		&code.ClientID,
+		&code.UserID,
+		&code.RedirectURI,
+		pq.Array(&code.Scopes),
+		&code.CodeChallenge,
+		&code.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &code, nil
+}
+
+type OAuthConsentModel struct {
+	DB *sql.DB
+}
+
+// Get returns the scopes the user has already consented to for the client, or an
+// empty slice if they haven't consented to anything yet.
+func (m OAuthConsentModel) Get(userID, clientID int64) ([]string, error) {
+	query := `
+	SELECT scopes
+	FROM oauth_consents
+	WHERE user_id = $1 AND client_id = $2`
+
+	scopes := []string{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, clientID).Scan(pq.Array(&scopes))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	return scopes, nil
+}
+
+// Grant records the user's consent, replacing whatever they had consented to before.
+func (m OAuthConsentModel) Grant(userID, clientID int64, scopes []string) error {
+	query := `
+	INSERT INTO oauth_consents (user_id, client_id, scopes)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (user_id, client_id) DO UPDATE SET scopes = EXCLUDED.scopes, created_at = NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, clientID, pq.Array(scopes))
+	return err
+}
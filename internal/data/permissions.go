@@ -3,6 +3,9 @@ package data
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
@@ -10,26 +13,95 @@ import (
 
 type Permissions []string
 
+// Include reports whether the permissions include code. A wildcard permission such as
+// "movies:*" includes every code starting with "movies:".
 func (p Permissions) Include(code string) bool {
 	for i := range p {
 		if code == p[i] {
 			return true
 		}
+		if prefix, ok := strings.CutSuffix(p[i], "*"); ok && strings.HasPrefix(code, prefix) {
+			return true
+		}
 	}
 	return false
 }
 
+// IncludeAll reports whether the permissions include every one of codes.
+func (p Permissions) IncludeAll(codes Permissions) bool {
+	for _, code := range codes {
+		if !p.Include(code) {
+			return false
+		}
+	}
+	return true
+}
+
+// Intersect returns the codes from scopes which are also included in p. It's used to
+// narrow a user's permissions down to those granted to one of their API keys.
+func (p Permissions) Intersect(scopes []string) Permissions {
+	intersection := Permissions{}
+	for _, scope := range scopes {
+		if p.Include(scope) {
+			intersection = append(intersection, scope)
+		}
+	}
+	return intersection
+}
+
+// Union returns the codes in either p or other, without duplicates.
+func (p Permissions) Union(other Permissions) Permissions {
+	union := slices.Clone(p)
+	for _, code := range other {
+		if !slices.Contains(union, code) {
+			union = append(union, code)
+		}
+	}
+	return union
+}
+
+// PermissionDefaults holds the permissions which don't have to be granted to anyone
+// explicitly. Everyone has the Anonymous permissions, even requests which aren't
+// authenticated at all. The Registration permissions are granted to new users, and the
+// Activation permissions when they activate their account.
+type PermissionDefaults struct {
+	Anonymous    Permissions
+	Registration Permissions
+	Activation   Permissions
+}
+
+// DefaultPermissions is set from the command-line flags in main.
+var DefaultPermissions = PermissionDefaults{
+	Registration: Permissions{"movies:read"},
+}
+
 type PermissionModel struct {
 	DB *sql.DB
 }
 
+// GetAllForUser returns a user's effective permissions: those granted to them directly,
+// those of their roles and every role those inherit from, and the anonymous ones.
 func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	if userID == AnonymousUser.ID {
+		return slices.Clone(DefaultPermissions.Anonymous), nil
+	}
+
 	query := `
+	WITH RECURSIVE user_roles AS (
+		SELECT role_id FROM users_roles WHERE user_id = $1
+		UNION
+		SELECT roles_parents.parent_id FROM roles_parents
+		INNER JOIN user_roles ON roles_parents.role_id = user_roles.role_id
+	)
 	SELECT permissions.code
 	FROM permissions
 	INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-	INNER JOIN users ON users_permissions.user_id = users.id
-	WHERE users.id = $1`
+	WHERE users_permissions.user_id = $1
+	UNION
+	SELECT permissions.code
+	FROM permissions
+	INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+	INNER JOIN user_roles ON roles_permissions.role_id = user_roles.role_id`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -57,10 +129,57 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 		return nil, err
 	}
 
+	return permissions.Union(DefaultPermissions.Anonymous), nil
+}
+
+// GetAll returns every permission code that exists.
+func (m PermissionModel) GetAll() (Permissions, error) {
+	query := `
+	SELECT code
+	FROM permissions
+	ORDER BY code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := Permissions{}
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
 	return permissions, nil
 }
 
 func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return addPermissionsForUser(ctx, m.DB, userID, codes)
+}
+
+func addPermissionsForUser(ctx context.Context, q querier, userID int64, codes []string) error {
+	if len(codes) == 0 {
+		return nil
+	}
+
 	// In this query the $1 parameter will be the user’s ID, and the $2 parameter will be a
 	// PostgreSQL array of the permission codes that we want to add for the user, like
 	// {'movies:read', 'movies:write'} .
@@ -69,11 +188,97 @@ func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 	// codesin the array. Then we insert the contents of this interim table into our user_permissions table
 	query := `
 	INSERT INTO users_permissions
-	SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+	SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+	ON CONFLICT DO NOTHING`
 
+	_, err := q.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
+
+func (m PermissionModel) RemoveForUser(userID int64, codes ...string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	return removePermissionsForUser(ctx, m.DB, userID, codes)
+}
+
+func removePermissionsForUser(ctx context.Context, q querier, userID int64, codes []string) error {
+	query := `
+	DELETE FROM users_permissions
+	USING permissions
+	WHERE users_permissions.permission_id = permissions.id
+	AND users_permissions.user_id = $1
+	AND permissions.code = ANY($2)`
+
+	_, err := q.ExecContext(ctx, query, userID, pq.Array(codes))
 	return err
 }
+
+const (
+	PermissionGrant        = "grant"
+	PermissionRevoke       = "revoke"
+	PermissionAssignRole   = "assign_role"
+	PermissionUnassignRole = "unassign_role"
+)
+
+// PermissionChange records who granted or revoked which permissions for a user, or
+// assigned or unassigned which roles, in which case Codes holds the role names.
+type PermissionChange struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	ActorID   int64     `json:"actor_id"`
+	UserID    int64     `json:"user_id"`
+	Action    string    `json:"action"`
+	Codes     []string  `json:"codes"`
+}
+
+type PermissionChangeModel struct {
+	DB *sql.DB
+}
+
+// Apply makes the change to the user's permissions or roles and records it, in one
+// transaction, so that there's never a change which isn't recorded.
+func (m PermissionChangeModel) Apply(change *PermissionChange) error {
+	var apply func(ctx context.Context, q querier, userID int64, codes []string) error
+
+	switch change.Action {
+	case PermissionGrant:
+		apply = addPermissionsForUser
+	case PermissionRevoke:
+		apply = removePermissionsForUser
+	case PermissionAssignRole:
+		apply = assignRolesToUser
+	case PermissionUnassignRole:
+		apply = unassignRolesFromUser
+	default:
+		return fmt.Errorf("unknown permission change action %q", change.Action)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = apply(ctx, tx, change.UserID, change.Codes)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO permission_changes (actor_id, user_id, action, codes)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at`
+
+	args := []any{change.ActorID, change.UserID, change.Action, pq.Array(change.Codes)}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&change.ID, &change.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,32 @@
+package data
+
+import "testing"
+
+func TestPermissionsInclude(t *testing.T) {
+	permissions := Permissions{"movies:read", "users:*"}
+
+	tests := []struct {
+		code string
+		want bool
+	}{
+		{"movies:read", true},
+		{"movies:write", false},
+		{"users:admin", true},
+		{"users:", true},
+		{"usersx:admin", false},
+		{"permissions:admin", false},
+	}
+
+	for _, tt := range tests {
+		if got := permissions.Include(tt.code); got != tt.want {
+			t.Errorf("Include(%q): got %t; want %t", tt.code, got, tt.want)
+		}
+	}
+
+	if !permissions.IncludeAll(Permissions{"movies:read", "users:admin"}) {
+		t.Error("IncludeAll: got false for codes which are all included")
+	}
+	if permissions.IncludeAll(Permissions{"movies:read", "movies:write"}) {
+		t.Error("IncludeAll: got true with a code which isn't included")
+	}
+}
@@ -0,0 +1,80 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// RateLimitModel keeps token buckets in the database, so that API instances share them,
+// using the generic cell rate algorithm (GCRA). A bucket is just the time it will be
+// full again, and one request's token is one emission interval (1/rps) added to it.
+type RateLimitModel struct {
+	DB *sql.DB
+}
+
+// RateLimitState is a bucket after Take, measured from the database's clock so that
+// the API instances don't need to agree on the time.
+type RateLimitState struct {
+	// Until is how long until the bucket is full again.
+	Until time.Duration
+}
+
+// Take takes n tokens from the bucket for key, which fills at one token per interval
+// up to burst, if they are all there. It's a single upsert, so concurrent requests from
+// different instances can't both take the last token. ok is false if there weren't
+// enough tokens, and the bucket is left as it was.
+func (m RateLimitModel) Take(key string, interval time.Duration, burst, n int) (state RateLimitState, ok bool, err error) {
+	query := `
+	INSERT INTO rate_limits (key, tat)
+	VALUES ($1, extract(epoch FROM now()) + $2::double precision * $4::integer)
+	ON CONFLICT (key) DO UPDATE
+	SET tat = greatest(rate_limits.tat, extract(epoch FROM now())) + $2::double precision * $4::integer
+	WHERE greatest(rate_limits.tat, extract(epoch FROM now())) - extract(epoch FROM now()) <= $2::double precision * ($3::integer - $4::integer)
+	RETURNING tat - extract(epoch FROM now())`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var until float64
+
+	err = m.DB.QueryRowContext(ctx, query, key, interval.Seconds(), burst, n).Scan(&until)
+	if err == nil {
+		return RateLimitState{Until: seconds(until)}, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return RateLimitState{}, false, err
+	}
+
+	// The bucket didn't have enough tokens, so the upsert didn't return it.
+	query = `
+	SELECT greatest(tat - extract(epoch FROM now()), 0)
+	FROM rate_limits
+	WHERE key = $1`
+
+	err = m.DB.QueryRowContext(ctx, query, key).Scan(&until)
+	if err != nil {
+		return RateLimitState{}, false, err
+	}
+
+	return RateLimitState{Until: seconds(until)}, false, nil
+}
+
+// DeleteFull deletes the buckets which have filled up again. They are the same as
+// buckets which were never there.
+func (m RateLimitModel) DeleteFull() error {
+	query := `
+	DELETE FROM rate_limits
+	WHERE tat < extract(epoch FROM now())`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query)
+	return err
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,97 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// A Revocation is an entry in the deny-list for stateless (JWT) authentication tokens.
+// It either revokes a single token by its ID, or every token issued to a user up to
+// the moment of revocation. Revocations are kept until the tokens they cover have
+// expired, even if the user is deleted in the meantime, since verifying a JWT doesn't
+// check that its user still exists.
+type Revocation struct {
+	ID        int64     `json:"id"`
+	JTI       *string   `json:"jti,omitempty"`
+	UserID    *int64    `json:"user_id,omitempty"`
+	RevokedAt time.Time `json:"revoked_at"`
+	Expiry    time.Time `json:"expiry"`
+}
+
+type RevocationModel struct {
+	DB *sql.DB
+}
+
+func (m RevocationModel) Insert(revocation *Revocation) error {
+	// ON CONFLICT lets the same token be revoked twice (e.g. a logout racing an
+	// emergency revocation) without returning an error to the client.
+	query := `
+	INSERT INTO revoked_tokens (jti, user_id, expiry)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (jti) DO UPDATE SET expiry = EXCLUDED.expiry
+	RETURNING id, revoked_at`
+
+	args := []any{revocation.JTI, revocation.UserID, revocation.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&revocation.ID, &revocation.RevokedAt)
+}
+
+// GetAllActive returns the revocations which still matter, i.e. those covering tokens
+// that haven't expired yet.
+func (m RevocationModel) GetAllActive() ([]*Revocation, error) {
+	query := `
+	SELECT id, jti, user_id, revoked_at, expiry
+	FROM revoked_tokens
+	WHERE expiry > $1
+	ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revocations := []*Revocation{}
+
+	for rows.Next() {
+		var revocation Revocation
+
+		err := rows.Scan(
+			&revocation.ID,
+			&revocation.JTI,
+			&revocation.UserID,
+			&revocation.RevokedAt,
+			&revocation.Expiry,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		revocations = append(revocations, &revocation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revocations, nil
+}
+
+func (m RevocationModel) DeleteExpired() error {
+	query := `
+	DELETE FROM revoked_tokens
+	WHERE expiry <= $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now())
+	return err
+}
@@ -0,0 +1,337 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateRoleName = errors.New("duplicate role name")
+	ErrRoleCycle         = errors.New("role would inherit from itself")
+)
+
+// Role is a named bundle of permissions. A role also has all the permissions of its
+// parent roles, so for example an editor role can inherit from a viewer role and only
+// add the permissions viewers don't have.
+type Role struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Permissions []string  `json:"permissions"`
+	Parents     []string  `json:"parents"`
+	Version     int32     `json:"version"`
+}
+
+type RoleModel struct {
+	DB *sql.DB
+}
+
+// roleColumns selects a role along with the codes of its own permissions and the names
+// of its parents.
+const roleColumns = `
+	roles.id, roles.created_at, roles.name, roles.description, roles.version,
+	ARRAY(
+		SELECT permissions.code FROM roles_permissions
+		INNER JOIN permissions ON permissions.id = roles_permissions.permission_id
+		WHERE roles_permissions.role_id = roles.id
+		ORDER BY permissions.code
+	),
+	ARRAY(
+		SELECT parents.name::text FROM roles_parents
+		INNER JOIN roles AS parents ON parents.id = roles_parents.parent_id
+		WHERE roles_parents.role_id = roles.id
+		ORDER BY parents.name
+	)`
+
+func scanRole(row interface{ Scan(...any) error }) (*Role, error) {
+	var role Role
+
+	err := row.Scan(
+		&role.ID,
+		&role.CreatedAt,
+		&role.Name,
+		&role.Description,
+		&role.Version,
+		pq.Array(&role.Permissions),
+		pq.Array(&role.Parents),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &role, nil
+}
+
+func (m RoleModel) Insert(role *Role) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO roles (name, description)
+	VALUES ($1, $2)
+	RETURNING id, created_at, version`
+
+	err = tx.QueryRowContext(ctx, query, role.Name, role.Description).Scan(&role.ID, &role.CreatedAt, &role.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "roles_name_key"`:
+			return ErrDuplicateRoleName
+		default:
+			return err
+		}
+	}
+
+	// A new role has no children yet, so its parents can't form a cycle.
+	err = setRoleRelations(ctx, tx, role)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m RoleModel) Get(id int64) (*Role, error) {
+	query := `SELECT ` + roleColumns + `
+	FROM roles
+	WHERE roles.id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	role, err := scanRole(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return role, nil
+}
+
+func (m RoleModel) GetAll() ([]*Role, error) {
+	query := `SELECT ` + roleColumns + `
+	FROM roles
+	ORDER BY roles.id`
+
+	return m.query(query)
+}
+
+// GetAllForUser retrieves the roles assigned directly to a user.
+func (m RoleModel) GetAllForUser(userID int64) ([]*Role, error) {
+	query := `SELECT ` + roleColumns + `
+	FROM roles
+	INNER JOIN users_roles ON users_roles.role_id = roles.id
+	WHERE users_roles.user_id = $1
+	ORDER BY roles.id`
+
+	return m.query(query, userID)
+}
+
+func (m RoleModel) query(query string, args ...any) ([]*Role, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// Update saves the role and replaces its permissions and parents. It returns
+// ErrRoleCycle if the role would end up inheriting from itself.
+func (m RoleModel) Update(role *Role) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE roles
+	SET name = $1, description = $2, version = version + 1
+	WHERE id = $3 AND version = $4
+	RETURNING version`
+
+	args := []any{role.Name, role.Description, role.ID, role.Version}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&role.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "roles_name_key"`:
+			return ErrDuplicateRoleName
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	for _, query := range []string{
+		`DELETE FROM roles_permissions WHERE role_id = $1`,
+		`DELETE FROM roles_parents WHERE role_id = $1`,
+	} {
+		_, err = tx.ExecContext(ctx, query, role.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = setRoleRelations(ctx, tx, role)
+	if err != nil {
+		return err
+	}
+
+	query = `
+	WITH RECURSIVE ancestors AS (
+		SELECT parent_id FROM roles_parents WHERE role_id = $1
+		UNION
+		SELECT roles_parents.parent_id FROM roles_parents
+		INNER JOIN ancestors ON roles_parents.role_id = ancestors.parent_id
+	)
+	SELECT EXISTS (SELECT 1 FROM ancestors WHERE parent_id = $1)`
+
+	var cycle bool
+
+	err = tx.QueryRowContext(ctx, query, role.ID).Scan(&cycle)
+	if err != nil {
+		return err
+	}
+
+	if cycle {
+		return ErrRoleCycle
+	}
+
+	return tx.Commit()
+}
+
+func setRoleRelations(ctx context.Context, tx *sql.Tx, role *Role) error {
+	query := `
+	INSERT INTO roles_permissions
+	SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+	_, err := tx.ExecContext(ctx, query, role.ID, pq.Array(role.Permissions))
+	if err != nil {
+		return err
+	}
+
+	query = `
+	INSERT INTO roles_parents
+	SELECT $1, roles.id FROM roles WHERE roles.name = ANY($2::citext[])`
+
+	_, err = tx.ExecContext(ctx, query, role.ID, pq.Array(role.Parents))
+	return err
+}
+
+func (m RoleModel) Delete(id int64) error {
+	query := `
+	DELETE FROM roles
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// AssignToUser gives the user the named roles. Roles the user already has are skipped.
+func (m RoleModel) AssignToUser(userID int64, names ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return assignRolesToUser(ctx, m.DB, userID, names)
+}
+
+func assignRolesToUser(ctx context.Context, q querier, userID int64, names []string) error {
+	query := `
+	INSERT INTO users_roles
+	SELECT $1, roles.id FROM roles WHERE roles.name = ANY($2::citext[])
+	ON CONFLICT DO NOTHING`
+
+	_, err := q.ExecContext(ctx, query, userID, pq.Array(names))
+	return err
+}
+
+func (m RoleModel) UnassignFromUser(userID int64, names ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return unassignRolesFromUser(ctx, m.DB, userID, names)
+}
+
+func unassignRolesFromUser(ctx context.Context, q querier, userID int64, names []string) error {
+	query := `
+	DELETE FROM users_roles
+	USING roles
+	WHERE users_roles.role_id = roles.id
+	AND users_roles.user_id = $1
+	AND roles.name = ANY($2::citext[])`
+
+	_, err := q.ExecContext(ctx, query, userID, pq.Array(names))
+	return err
+}
+
+func ValidateRole(v *validator.Validator, role *Role) {
+	v.Check(role.Name != "", "name", "must be provided")
+	v.Check(len(role.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(role.Description) <= 1000, "description", "must not be more than 1000 bytes long")
+
+	v.Check(role.Permissions != nil, "permissions", "must be provided")
+	v.Check(validator.Unique(role.Permissions), "permissions", "must not contain duplicate values")
+
+	v.Check(role.Parents != nil, "parents", "must be provided")
+	v.Check(validator.Unique(role.Parents), "parents", "must not contain duplicate values")
+	for _, parent := range role.Parents {
+		v.Check(!strings.EqualFold(parent, role.Name), "parents", "must not contain the role itself")
+	}
+}
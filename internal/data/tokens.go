@@ -6,14 +6,22 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 
 	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
+	"github.com/lib/pq"
 )
 
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopeOAuthAccess    = "oauth_access"
+	ScopeMFAPending     = "mfa_pending"
+	ScopeUnlock         = "unlock"
+	ScopePasswordReset  = "password-reset"
+	ScopeMagicLink      = "magic-link"
+	ScopeSecureAccount  = "secure-account"
 )
 
 type Token struct {
@@ -22,6 +30,14 @@ type Token struct {
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	// ClientID and Permissions are only set for tokens issued to OAuth clients, which
+	// may only use the subset of the user's permissions that was granted to them.
+	ClientID    *int64   `json:"-"`
+	Permissions []string `json:"-"`
+	// ImpersonationID is only set for tokens given to support staff to act as the
+	// user, and ImpersonatorID, when the token has been looked up, is who they are.
+	ImpersonationID *int64 `json:"-"`
+	ImpersonatorID  *int64 `json:"-"`
 }
 
 type TokenModel struct {
@@ -68,12 +84,41 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// NewForClient creates an OAuth access token which lets the client act for the user
+// with the given permissions only.
+func (m TokenModel) NewForClient(userID, clientID int64, ttl time.Duration, permissions []string) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeOAuthAccess)
+	if err != nil {
+		return nil, err
+	}
+
+	token.ClientID = &clientID
+	token.Permissions = permissions
+
+	err = m.Insert(token)
+	return token, err
+}
+
+// NewForImpersonation creates an authentication token for the user which is tied to an
+// impersonation, see Impersonation.
+func (m TokenModel) NewForImpersonation(userID, impersonationID int64, ttl time.Duration) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+
+	token.ImpersonationID = &impersonationID
+
+	err = m.Insert(token)
+	return token, err
+}
+
 func (m TokenModel) Insert(token *Token) error {
 	query := `
-	INSERT INTO tokens (hash, user_id, expiry, scope)
-	VALUES ($1, $2, $3, $4)`
+	INSERT INTO tokens (hash, user_id, expiry, scope, client_id, permissions, impersonation_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`
 
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.ClientID, pq.Array(token.Permissions), token.ImpersonationID}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -94,6 +139,136 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	return err
 }
 
+// DeleteAllForUserExcept is DeleteAllForUser, but keeps the token with the given hash.
+func (m TokenModel) DeleteAllForUserExcept(scope string, userID int64, hash []byte) error {
+	query := `
+	DELETE FROM tokens
+	WHERE scope = $1 AND user_id = $2 AND hash <> $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, scope, userID, hash)
+	return err
+}
+
+// GetAllForUser retrieves the user's unexpired tokens of every scope. Only the hashes of
+// tokens are stored, so Plaintext is never set.
+func (m TokenModel) GetAllForUser(userID int64) ([]*Token, error) {
+	query := `
+	SELECT hash, user_id, expiry, scope, client_id, permissions
+	FROM tokens
+	WHERE user_id = $1 AND expiry > $2
+	ORDER BY expiry`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*Token{}
+
+	for rows.Next() {
+		var token Token
+
+		err := rows.Scan(
+			&token.Hash,
+			&token.UserID,
+			&token.Expiry,
+			&token.Scope,
+			&token.ClientID,
+			pq.Array(&token.Permissions),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, &token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// CountActiveForUser returns how many unexpired tokens with the given scope the user has.
+func (m TokenModel) CountActiveForUser(scope string, userID int64) (int, error) {
+	query := `
+	SELECT count(*)
+	FROM tokens
+	WHERE scope = $1 AND user_id = $2 AND expiry > $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+
+	err := m.DB.QueryRowContext(ctx, query, scope, userID, time.Now()).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// GetForHash retrieves an unexpired authentication or OAuth access token by its hash.
+func (m TokenModel) GetForHash(tokenHash []byte) (*Token, error) {
+	query := `
+	SELECT hash, user_id, expiry, scope, client_id, permissions
+	FROM tokens
+	WHERE hash = $1
+	AND scope = ANY($2)
+	AND expiry > $3`
+
+	scopes := []string{ScopeAuthentication, ScopeOAuthAccess}
+
+	var token Token
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash, pq.Array(scopes), time.Now()).Scan(
+		&token.Hash,
+		&token.UserID,
+		&token.Expiry,
+		&token.Scope,
+		&token.ClientID,
+		pq.Array(&token.Permissions),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &token, nil
+}
+
+// DeleteForHash deletes an authentication or OAuth access token by its hash. Deleting
+// a token which doesn't exist is not an error, as the outcome is the same.
+func (m TokenModel) DeleteForHash(tokenHash []byte) error {
+	query := `
+	DELETE FROM tokens
+	WHERE hash = $1 AND scope = ANY($2)`
+
+	scopes := []string{ScopeAuthentication, ScopeOAuthAccess}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, tokenHash, pq.Array(scopes))
+	return err
+}
+
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(tokenPlaintext != "", "token", "must be provided")
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
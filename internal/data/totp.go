@@ -0,0 +1,281 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
+)
+
+// TOTP is a user's two-factor authentication enrolment. It only takes effect once it
+// has been confirmed with a first valid code, which proves the authenticator app was
+// set up correctly.
+type TOTP struct {
+	UserID       int64
+	CreatedAt    time.Time
+	Secret       string
+	Confirmed    bool
+	LastUsedStep int64
+}
+
+type TOTPModel struct {
+	DB *sql.DB
+}
+
+// Enrol stores a new unconfirmed secret for the user, replacing any earlier
+// unconfirmed one. It returns ErrEditConflict if 2FA is already confirmed.
+func (m TOTPModel) Enrol(userID int64, secret string) error {
+	query := `
+	INSERT INTO users_totp (user_id, secret)
+	VALUES ($1, $2)
+	ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, created_at = NOW(), last_used_step = 0
+	WHERE users_totp.confirmed = false`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+func (m TOTPModel) Get(userID int64) (*TOTP, error) {
+	query := `
+	SELECT user_id, created_at, secret, confirmed, last_used_step
+	FROM users_totp
+	WHERE user_id = $1`
+
+	var totp TOTP
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&totp.UserID,
+		&totp.CreatedAt,
+		&totp.Secret,
+		&totp.Confirmed,
+		&totp.LastUsedStep,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &totp, nil
+}
+
+// UseStep records that the code for the given time step has been used. The update only
+// succeeds if no code for the same or a later step has been used, so the same code
+// can't be accepted twice.
+func (m TOTPModel) UseStep(userID, step int64) error {
+	query := `
+	UPDATE users_totp
+	SET last_used_step = $2
+	WHERE user_id = $1 AND last_used_step < $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// Delete turns two-factor authentication off for the user, along with any recovery
+// codes they have left.
+func (m TOTPModel) Delete(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM users_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+// Confirm completes enrolment with the code for the given time step and returns n
+// recovery codes, in one transaction so that 2FA is never enabled without them. Like
+// UseStep, it returns ErrEditConflict if the code for the step has already been used.
+func (m TOTPModel) Confirm(userID, step int64, n int) ([]string, error) {
+	query := `
+	UPDATE users_totp
+	SET last_used_step = $2, confirmed = true
+	WHERE user_id = $1 AND last_used_step < $2 AND NOT confirmed`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, ErrEditConflict
+	}
+
+	codes, err := replaceRecoveryCodes(ctx, tx, userID, n)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// NewRecoveryCodes replaces the user's recovery codes with n fresh ones and returns
+// them. Only their hashes are stored, so this is the only time they can be shown.
+func (m TOTPModel) NewRecoveryCodes(userID int64, n int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(ctx, tx, userID, n)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int64, n int) ([]string, error) {
+	_, err := tx.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, n)
+
+	for i := range codes {
+		code, err := randomString(10)
+		if err != nil {
+			return nil, err
+		}
+
+		// Split the 16 characters into two groups so they're easier to copy down.
+		codes[i] = strings.ToLower(code[:8] + "-" + code[8:])
+
+		hash := sha256.Sum256([]byte(codes[i]))
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO totp_recovery_codes (user_id, hash) VALUES ($1, $2)`, userID, hash[:])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
+
+// ConsumeRecoveryCode deletes a matching recovery code, returning ErrRecordNotFound if
+// there isn't one. Each code can therefore only be used once.
+func (m TOTPModel) ConsumeRecoveryCode(userID int64, code string) error {
+	hash := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+
+	query := `
+	DELETE FROM totp_recovery_codes
+	WHERE user_id = $1 AND hash = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, hash[:])
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func ValidateTOTPCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) == 6, "code", "must be 6 digits long")
+}
@@ -5,10 +5,14 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
+	"sync"
 	"time"
 
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/passhash"
+	"github.com/TomiwaAribisala-git/greenlight.git/internal/passpolicy"
 	"github.com/TomiwaAribisala-git/greenlight.git/internal/validator"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/lib/pq"
 )
 
 var (
@@ -41,6 +45,13 @@ func (u *User) IsAnonymous() bool {
 }
 
 func (m UserModel) Insert(user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return insertUser(ctx, m.DB, user)
+}
+
+func insertUser(ctx context.Context, q querier, user *User) error {
 	query := `
 	INSERT INTO users (name, email, password_hash, activated)
 	VALUES ($1, $2, $3, $4)
@@ -48,14 +59,11 @@ func (m UserModel) Insert(user *User) error {
 
 	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
 	// If the table already contains a record with this email address, then when we try
 	// to perform the insert there will be a violation of the UNIQUE "users_email_key"
 	// constraint that we set up in the previous chapter. We check for this error
 	// specifically, and return custom ErrDuplicateEmail error instead.
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	err := q.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -101,6 +109,174 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
+func (m UserModel) Get(id int64) (*User, error) {
+	query := `
+	SELECT id, created_at, name, email, password_hash, activated, version
+	FROM users
+	WHERE id = $1`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// GetAll returns a page of users whose name or email address contains search, which
+// is matched case-insensitively. An empty search matches everyone.
+func (m UserModel) GetAll(search string, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, name, email, password_hash, activated, version
+	FROM users
+	WHERE ($1 = '' OR strpos(lower(name), lower($1)) > 0 OR strpos(lower(email::text), lower($1)) > 0)
+	ORDER BY %s %s, id ASC
+	LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, search, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
+
+// GetAllForPermission retrieves the users who have been granted a permission, directly
+// or through their roles and the roles those inherit from, including by a wildcard
+// such as "movies:*". It matches PermissionModel.GetAllForUser(), bar the anonymous
+// permissions, which everyone has.
+func (m UserModel) GetAllForPermission(code string) ([]*User, error) {
+	query := `
+	WITH RECURSIVE user_roles AS (
+		SELECT user_id, role_id FROM users_roles
+		UNION
+		SELECT user_roles.user_id, roles_parents.parent_id FROM roles_parents
+		INNER JOIN user_roles ON roles_parents.role_id = user_roles.role_id
+	),
+	user_codes AS (
+		SELECT users_permissions.user_id, permissions.code
+		FROM permissions
+		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		UNION
+		SELECT user_roles.user_id, permissions.code
+		FROM permissions
+		INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+		INNER JOIN user_roles ON roles_permissions.role_id = user_roles.role_id
+	)
+	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+	FROM users
+	WHERE users.id IN (
+		SELECT user_id FROM user_codes
+		WHERE code = $1
+		OR (right(code, 1) = '*' AND left($1, length(code) - 1) = left(code, -1))
+	)
+	ORDER BY users.id`
+
+	return m.queryAll(query, code)
+}
+
+// GetAllCollaborators returns the users a movie has been shared with.
+func (m UserModel) GetAllCollaborators(movieID int64) ([]*User, error) {
+	query := `
+	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+	FROM users
+	INNER JOIN movies_collaborators ON movies_collaborators.user_id = users.id
+	WHERE movies_collaborators.movie_id = $1
+	ORDER BY users.id`
+
+	return m.queryAll(query, movieID)
+}
+
+func (m UserModel) queryAll(query string, args ...any) ([]*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
 func (m UserModel) Update(user *User) error {
 	query := `
 	UPDATE users
@@ -135,29 +311,269 @@ func (m UserModel) Update(user *User) error {
 	return nil
 }
 
-func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+// Delete deletes the user. Their tokens, API keys, permissions and so on go with them
+// through ON DELETE CASCADE.
+func (m UserModel) Delete(id int64) error {
+	query := `
+	DELETE FROM users
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
 
-	p.plaintext = &plaintextPassword
-	p.hash = hash
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
 
 	return nil
 }
 
-func (p *password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+// RequestDeletion records that the user has asked for their account to be deleted, and
+// returns when they asked. Asking again doesn't restart the grace period.
+func (m UserModel) RequestDeletion(id int64) (time.Time, error) {
+	query := `
+	UPDATE users
+	SET deletion_requested_at = coalesce(deletion_requested_at, NOW())
+	WHERE id = $1
+	RETURNING deletion_requested_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var requestedAt time.Time
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&requestedAt)
 	if err != nil {
 		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
+		case errors.Is(err, sql.ErrNoRows):
+			return time.Time{}, ErrRecordNotFound
 		default:
-			return false, err
+			return time.Time{}, err
 		}
 	}
-	return true, nil
+
+	return requestedAt, nil
+}
+
+// CancelDeletion withdraws a deletion request, reporting whether there was one.
+func (m UserModel) CancelDeletion(id int64) (bool, error) {
+	query := `
+	UPDATE users
+	SET deletion_requested_at = NULL
+	WHERE id = $1 AND deletion_requested_at IS NOT NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// GetAllDeletionsDue returns the IDs of users who asked for their account to be deleted
+// before the given time.
+func (m UserModel) GetAllDeletionsDue(before time.Time) ([]int64, error) {
+	query := `
+	SELECT id
+	FROM users
+	WHERE deletion_requested_at <= $1
+	ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// Anonymise removes everything which identifies the user, but keeps the users row so
+// that what they created still has an owner. Their credentials, permissions and links
+// to other accounts are deleted, and they can never log in again. Their address is
+// also taken out of the invitations and login failures which mention it, and the
+// reasons given for impersonating them are blanked.
+func (m UserModel) Anonymise(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldEmail string
+
+	err = tx.QueryRowContext(ctx, `SELECT email FROM users WHERE id = $1`, id).Scan(&oldEmail)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	for _, table := range []string{
+		"tokens",
+		"api_keys",
+		"oauth_clients",
+		"oauth_authorization_codes",
+		"oauth_consents",
+		"user_identities",
+		"users_totp",
+		"totp_recovery_codes",
+		"users_permissions",
+		"users_roles",
+		"movies_collaborators",
+		"data_exports",
+		"user_logins",
+		"notification_opt_outs",
+	} {
+		_, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE user_id = $1`, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	var password password
+
+	err = password.SetRandom()
+	if err != nil {
+		return err
+	}
+
+	query := `
+	UPDATE users
+	SET name = 'Deleted user', email = $1, password_hash = $2, activated = false,
+		deletion_requested_at = NULL, version = version + 1
+	WHERE id = $3`
+
+	// The address has to be unique, and .invalid is reserved so it can never be real.
+	email := fmt.Sprintf("deleted-%d@example.invalid", id)
+
+	_, err = tx.ExecContext(ctx, query, email, password.hash, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE invitations SET email = $1 WHERE email = $2`, email, oldEmail)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE impersonations SET reason = '' WHERE user_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	// The keys are made by loginKeys() in cmd/api.
+	_, err = tx.ExecContext(ctx, `DELETE FROM login_failures WHERE key = 'email:' || lower($1)`, oldEmail)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PasswordHasher makes the hashes for new passwords. Existing hashes are verified with
+// whichever algorithm made them.
+var PasswordHasher passhash.Hasher = passhash.DefaultArgon2id
+
+func (p *password) Set(plaintextPassword string) error {
+	hash, err := PasswordHasher.Hash(plaintextPassword)
+	if err != nil {
+		return err
+	}
+
+	p.plaintext = &plaintextPassword
+	p.hash = hash
+
+	return nil
+}
+
+// SetRandom sets the password to a random value which is never revealed. It's used for
+// accounts provisioned through single sign-on, which don't have a password of their own.
+func (p *password) SetRandom() error {
+	plaintextPassword, err := randomString(32)
+	if err != nil {
+		return err
+	}
+
+	hash, err := PasswordHasher.Hash(plaintextPassword)
+	if err != nil {
+		return err
+	}
+
+	p.plaintext = nil
+	p.hash = hash
+
+	return nil
+}
+
+// NeedsRehash reports whether the password hash was made with an algorithm or
+// parameters other than the current PasswordHasher's.
+func (p *password) NeedsRehash() bool {
+	return PasswordHasher.NeedsRehash(p.hash)
+}
+
+var (
+	dummyPasswordHash     []byte
+	dummyPasswordHashOnce sync.Once
+)
+
+// DummyPasswordMatches does the same amount of work as password.Matches but always
+// fails. Login uses it for unknown email addresses, so that response times don't give
+// away which addresses have accounts.
+func DummyPasswordMatches(plaintextPassword string) {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = PasswordHasher.Hash("greenlight-dummy-password")
+	})
+
+	_, _ = passhash.Verify(plaintextPassword, dummyPasswordHash)
+}
+
+func (p *password) Matches(plaintextPassword string) (bool, error) {
+	return passhash.Verify(plaintextPassword, p.hash)
 }
 
 // one(user) to many(tokens) relationship
@@ -207,6 +623,63 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	return &user, nil
 }
 
+// GetForAccessToken retrieves the user for a bearer token used to access the API,
+// which is either an authentication token or an access token issued to an OAuth
+// client. The token is returned as well so the caller can tell them apart.
+func (m UserModel) GetForAccessToken(tokenPlaintext string) (*User, *Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version,
+		tokens.expiry, tokens.scope, tokens.client_id, tokens.permissions,
+		tokens.impersonation_id, impersonations.actor_id
+	FROM users
+	INNER JOIN tokens
+	ON users.id = tokens.user_id
+	LEFT JOIN impersonations
+	ON impersonations.id = tokens.impersonation_id
+	WHERE tokens.hash = $1
+	AND tokens.scope = ANY($2)
+	AND tokens.expiry > $3`
+
+	scopes := []string{ScopeAuthentication, ScopeOAuthAccess}
+	args := []any{tokenHash[:], pq.Array(scopes), time.Now()}
+
+	var user User
+	token := Token{Hash: tokenHash[:]}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&token.Expiry,
+		&token.Scope,
+		&token.ClientID,
+		pq.Array(&token.Permissions),
+		&token.ImpersonationID,
+		&token.ImpersonatorID,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	token.UserID = user.ID
+
+	return &user, &token, nil
+}
+
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
@@ -215,7 +688,34 @@ func ValidateEmail(v *validator.Validator, email string) {
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	v.Check(password != "", "password", "must be provided")
 	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	v.Check(len(password) <= PasswordHasher.MaxLength(), "password", fmt.Sprintf("must not be more than %d bytes long", PasswordHasher.MaxLength()))
+}
+
+// maxPasswordAttemptLength only stops absurdly large inputs being hashed.
+const maxPasswordAttemptLength = 1024
+
+// ValidatePasswordAttempt checks a password given to log in or to confirm an action,
+// rather than one being set. Whether it's too long for the user's password hash to
+// match is up to the hash, which may have been made by a different hasher than the
+// current PasswordHasher.
+func ValidatePasswordAttempt(v *validator.Validator, password string) {
+	v.Check(password != "", "password", "must be provided")
+	v.Check(len(password) <= maxPasswordAttemptLength, "password", fmt.Sprintf("must not be more than %d bytes long", maxPasswordAttemptLength))
+}
+
+// PasswordPolicy is what new passwords are checked against.
+var PasswordPolicy = passpolicy.Policy{MinLength: 8, MinScore: 2}
+
+// ValidateNewPassword checks a password which is being set, on registration, reset or
+// change, against PasswordPolicy. Existing passwords are never checked against the
+// policy (ValidatePasswordAttempt is used at login), so tightening it doesn't lock
+// anyone out.
+func ValidateNewPassword(v *validator.Validator, user *User, password string) {
+	ValidatePasswordPlaintext(v, password)
+
+	for _, problem := range PasswordPolicy.Check(password, user.Name, user.Email) {
+		v.AddError("password", problem)
+	}
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
@@ -37,7 +37,9 @@ func (l Level) String() string {
 type Logger struct {
 	out      io.Writer
 	minLevel Level
-	mu       sync.Mutex
+	mu       *sync.Mutex
+	// properties are added to every entry, see With().
+	properties map[string]string
 }
 
 // Return a new Logger instance which writes log entries at or above a minimum severity
@@ -46,6 +48,27 @@ func New(out io.Writer, minLevel Level) *Logger {
 	return &Logger{
 		out:      out,
 		minLevel: minLevel,
+		mu:       &sync.Mutex{},
+	}
+}
+
+// With returns a Logger which adds the given properties to every entry, as well as any
+// the Logger already adds. It writes to the same destination, and properties given
+// for a single entry take precedence.
+func (L *Logger) With(properties map[string]string) *Logger {
+	merged := make(map[string]string, len(L.properties)+len(properties))
+	for key, value := range L.properties {
+		merged[key] = value
+	}
+	for key, value := range properties {
+		merged[key] = value
+	}
+
+	return &Logger{
+		out:        L.out,
+		minLevel:   L.minLevel,
+		mu:         L.mu,
+		properties: merged,
 	}
 }
 
@@ -83,6 +106,16 @@ func (L *Logger) print(level Level, message string, properties map[string]string
 		Properties: properties,
 	}
 
+	if len(L.properties) > 0 {
+		aux.Properties = make(map[string]string, len(L.properties)+len(properties))
+		for key, value := range L.properties {
+			aux.Properties[key] = value
+		}
+		for key, value := range properties {
+			aux.Properties[key] = value
+		}
+	}
+
 	// Include a stack trace for entries at the ERROR and FATAL levels.
 	if level >= LevelError {
 		aux.Trace = string(debug.Stack())
@@ -0,0 +1,272 @@
+// Stateless Authentication Tokens: signed JSON Web Tokens (RFC 7519) which carry
+// everything the authenticate() middleware needs, so it doesn't have to hit the database.
+
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	AlgEdDSA = "EdDSA"
+	AlgHS256 = "HS256"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("expired token")
+	ErrUnknownKey   = errors.New("unknown signing key")
+)
+
+// base64url without padding is used for every segment of a JWT.
+var b64 = base64.RawURLEncoding
+
+// Claims holds the registered claims we use, plus the user details which would
+// otherwise have to be looked up in the users and permissions tables.
+type Claims struct {
+	Issuer      string   `json:"iss"`
+	Subject     string   `json:"sub"`
+	IssuedAt    int64    `json:"iat"`
+	Expiry      int64    `json:"exp"`
+	ID          string   `json:"jti"`
+	Activated   bool     `json:"activated"`
+	Permissions []string `json:"permissions"`
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+// Key is a single signing key. For EdDSA both the private and public halves are kept,
+// for HS256 only the shared secret.
+type Key struct {
+	ID      string
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+	secret  []byte
+}
+
+// JWK is the JSON Web Key (RFC 7517) representation of a public key, as served by the
+// JWKS endpoint.
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+}
+
+// KeySet signs with the first (active) key and verifies with any of them, which is
+// what allows keys to be rotated without invalidating tokens that are still in flight.
+type KeySet struct {
+	alg  string
+	keys []Key
+}
+
+// ParseKeys builds a KeySet from a comma-separated list of "kid:base64key" entries.
+// For EdDSA each key is a base64-encoded 32 byte ed25519 seed, for HS256 it is the
+// base64-encoded shared secret (at least 32 bytes).
+func ParseKeys(alg, spec string) (*KeySet, error) {
+	if alg != AlgEdDSA && alg != AlgHS256 {
+		return nil, fmt.Errorf("jwt: unsupported algorithm %q", alg)
+	}
+
+	ks := &KeySet{alg: alg}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		kid, encoded, found := strings.Cut(entry, ":")
+		if !found || kid == "" {
+			return nil, fmt.Errorf("jwt: key entry must be in the format kid:base64key")
+		}
+
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("jwt: key %q is not valid base64: %w", kid, err)
+		}
+
+		key := Key{ID: kid}
+
+		switch alg {
+		case AlgEdDSA:
+			if len(raw) != ed25519.SeedSize {
+				return nil, fmt.Errorf("jwt: key %q must be a %d byte ed25519 seed", kid, ed25519.SeedSize)
+			}
+			key.private = ed25519.NewKeyFromSeed(raw)
+			key.public = key.private.Public().(ed25519.PublicKey)
+		case AlgHS256:
+			if len(raw) < 32 {
+				return nil, fmt.Errorf("jwt: key %q must be at least 32 bytes long", kid)
+			}
+			key.secret = raw
+		}
+
+		ks.keys = append(ks.keys, key)
+	}
+
+	if len(ks.keys) == 0 {
+		return nil, errors.New("jwt: at least one signing key must be provided")
+	}
+
+	return ks, nil
+}
+
+// Sign fills in the issued at, expiry and ID claims and returns the compact
+// serialization of the token signed with the active key.
+func (ks *KeySet) Sign(claims *Claims, ttl time.Duration) (string, error) {
+	jti := make([]byte, 16)
+	_, err := rand.Read(jti)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims.IssuedAt = now.Unix()
+	claims.Expiry = now.Add(ttl).Unix()
+	claims.ID = b64.EncodeToString(jti)
+
+	key := ks.keys[0]
+
+	headerJSON, err := json.Marshal(header{Alg: ks.alg, Typ: "JWT", Kid: key.ID})
+	if err != nil {
+		return "", err
+	}
+
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := b64.EncodeToString(headerJSON) + "." + b64.EncodeToString(claimsJSON)
+
+	return signingInput + "." + b64.EncodeToString(ks.sign(key, []byte(signingInput))), nil
+}
+
+// Verify checks the signature and expiry of a token and returns its claims. It never
+// touches the database; revocation is handled separately by the caller.
+func (ks *KeySet) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	headerJSON, err := b64.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+	err = json.Unmarshal(headerJSON, &h)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	// Never trust the algorithm in the header to pick how we verify, otherwise a token
+	// claiming "none" or switching EdDSA for HS256 could slip through.
+	if h.Alg != ks.alg {
+		return nil, ErrInvalidToken
+	}
+
+	key, ok := ks.lookup(h.Kid)
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	signature, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if !ks.verify(key, []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidToken
+	}
+
+	claimsJSON, err := b64.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	err = json.Unmarshal(claimsJSON, &claims)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if time.Now().Unix() >= claims.Expiry {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
+
+// JWKS returns the public keys in the set. HS256 secrets are symmetric and must never
+// be published, so the list is empty in that mode.
+func (ks *KeySet) JWKS() []JWK {
+	jwks := []JWK{}
+
+	if ks.alg != AlgEdDSA {
+		return jwks
+	}
+
+	for _, key := range ks.keys {
+		jwks = append(jwks, JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   b64.EncodeToString(key.public),
+			Kid: key.ID,
+			Use: "sig",
+			Alg: AlgEdDSA,
+		})
+	}
+
+	return jwks
+}
+
+func (ks *KeySet) lookup(kid string) (Key, bool) {
+	for _, key := range ks.keys {
+		if key.ID == kid {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+func (ks *KeySet) sign(key Key, input []byte) []byte {
+	if ks.alg == AlgEdDSA {
+		return ed25519.Sign(key.private, input)
+	}
+
+	mac := hmac.New(sha256.New, key.secret)
+	mac.Write(input)
+	return mac.Sum(nil)
+}
+
+func (ks *KeySet) verify(key Key, input, signature []byte) bool {
+	if ks.alg == AlgEdDSA {
+		return ed25519.Verify(key.public, input, signature)
+	}
+
+	return hmac.Equal(ks.sign(key, input), signature)
+}
+
+// LooksLikeJWT reports whether a bearer token is in the compact JWT format rather than
+// one of our 26 character opaque tokens.
+func LooksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
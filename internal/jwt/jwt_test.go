@@ -0,0 +1,132 @@
+package jwt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Keys are given to ParseKeys base64-encoded, as they are on the command line.
+func testKey(kid string, b byte, size int) string {
+	return kid + ":" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(rune(b)), size)))
+}
+
+func mustParseKeys(t *testing.T, alg string, keys ...string) *KeySet {
+	t.Helper()
+
+	ks, err := ParseKeys(alg, strings.Join(keys, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks
+}
+
+func mustSign(t *testing.T, ks *KeySet, ttl time.Duration) string {
+	t.Helper()
+
+	token, err := ks.Sign(&Claims{Subject: "42", Activated: true, Permissions: []string{"movies:read"}}, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// forge builds a token with the given header and claims, signed with the set's first
+// key whatever the header says.
+func forge(t *testing.T, ks *KeySet, h header, claims Claims) string {
+	t.Helper()
+
+	headerJSON, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := b64.EncodeToString(headerJSON) + "." + b64.EncodeToString(claimsJSON)
+	return input + "." + b64.EncodeToString(ks.sign(ks.keys[0], []byte(input)))
+}
+
+func TestVerify(t *testing.T) {
+	ed := mustParseKeys(t, AlgEdDSA, testKey("k1", 'a', 32))
+	edOther := mustParseKeys(t, AlgEdDSA, testKey("k1", 'b', 32))
+	edRotated := mustParseKeys(t, AlgEdDSA, testKey("k2", 'c', 32), testKey("k1", 'a', 32))
+	hs := mustParseKeys(t, AlgHS256, testKey("k1", 'a', 32))
+
+	valid := mustSign(t, ed, time.Hour)
+	parts := strings.Split(valid, ".")
+
+	future := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name    string
+		ks      *KeySet
+		token   string
+		wantErr error
+	}{
+		{"valid", ed, valid, nil},
+		{"valid HS256", hs, mustSign(t, hs, time.Hour), nil},
+		{"signed with a key being rotated out", edRotated, valid, nil},
+		{"expired", ed, mustSign(t, ed, -time.Second), ErrExpiredToken},
+		{"unknown key ID", mustParseKeys(t, AlgEdDSA, testKey("k2", 'a', 32)), valid, ErrUnknownKey},
+		{"signed with another key with the same ID", edOther, valid, ErrInvalidToken},
+		{"tampered claims", ed, parts[0] + "." + b64.EncodeToString([]byte(`{"sub":"1","exp":9999999999}`)) + "." + parts[2], ErrInvalidToken},
+		{"missing signature", ed, parts[0] + "." + parts[1] + ".", ErrInvalidToken},
+		{"signature not base64url", ed, parts[0] + "." + parts[1] + ".!!!", ErrInvalidToken},
+		{"two segments", ed, parts[0] + "." + parts[1], ErrInvalidToken},
+		{"four segments", ed, valid + ".x", ErrInvalidToken},
+		{"header not JSON", ed, b64.EncodeToString([]byte("nope")) + "." + parts[1] + "." + parts[2], ErrInvalidToken},
+		{"alg none", ed, forge(t, ed, header{Alg: "none", Typ: "JWT", Kid: "k1"}, Claims{Subject: "42", Expiry: future}), ErrInvalidToken},
+		{"HS256 token for an EdDSA key set", ed, forge(t, hs, header{Alg: AlgHS256, Typ: "JWT", Kid: "k1"}, Claims{Subject: "42", Expiry: future}), ErrInvalidToken},
+		{"EdDSA token for an HS256 key set", hs, valid, ErrInvalidToken},
+		{"no expiry", ed, forge(t, ed, header{Alg: AlgEdDSA, Typ: "JWT", Kid: "k1"}, Claims{Subject: "42"}), ErrExpiredToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := tt.ks.Verify(tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if claims.Subject != "42" || !claims.Activated || len(claims.Permissions) != 1 || claims.Permissions[0] != "movies:read" {
+				t.Errorf("got claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		alg     string
+		spec    string
+		wantErr bool
+	}{
+		{"EdDSA seed", AlgEdDSA, testKey("k1", 'a', 32), false},
+		{"several keys", AlgEdDSA, testKey("k1", 'a', 32) + ", " + testKey("k2", 'b', 32), false},
+		{"HS256 secret", AlgHS256, testKey("k1", 'a', 48), false},
+		{"unsupported algorithm", "RS256", testKey("k1", 'a', 32), true},
+		{"no keys", AlgEdDSA, " , ", true},
+		{"missing key ID", AlgEdDSA, testKey("", 'a', 32), true},
+		{"not base64", AlgEdDSA, "k1:!!!", true},
+		{"short EdDSA seed", AlgEdDSA, testKey("k1", 'a', 31), true},
+		{"short HS256 secret", AlgHS256, testKey("k1", 'a', 31), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseKeys(tt.alg, tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v; want error: %t", err, tt.wantErr)
+			}
+		})
+	}
+}
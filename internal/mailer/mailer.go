@@ -15,6 +15,10 @@ var templateFS embed.FS
 type Mailer struct {
 	dialer *mail.Dialer
 	sender string
+	// headers are added to every message, see WithHeader().
+	headers map[string]string
+	// onSend is told the outcome of every Send(), see WithSendHook().
+	onSend func(templateFile string, err error)
 }
 
 func New(host string, port int, username, password, sender string) Mailer {
@@ -29,7 +33,30 @@ func New(host string, port int, username, password, sender string) Mailer {
 	}
 }
 
-func (m Mailer) Send(recipient, templateFile string, data any) error {
+// WithHeader returns a Mailer which adds the header to every message it sends.
+func (m Mailer) WithHeader(key, value string) Mailer {
+	headers := make(map[string]string, len(m.headers)+1)
+	for k, v := range m.headers {
+		headers[k] = v
+	}
+	headers[key] = value
+
+	m.headers = headers
+	return m
+}
+
+// WithSendHook returns a Mailer which calls fn after every Send(), with the template
+// and the error it returned, if any. It's used for counting the emails sent.
+func (m Mailer) WithSendHook(fn func(templateFile string, err error)) Mailer {
+	m.onSend = fn
+	return m
+}
+
+func (m Mailer) Send(recipient, templateFile string, data any) (err error) {
+	if m.onSend != nil {
+		defer func() { m.onSend(templateFile, err) }()
+	}
+
 	// Using embedded file systems: other use cases
 	// //go:embed "templates" embeds the contents of the directory at internal/mailer/templates
 	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
@@ -64,6 +91,9 @@ func (m Mailer) Send(recipient, templateFile string, data any) error {
 	msg.SetHeader("To", recipient)
 	msg.SetHeader("From", m.sender)
 	msg.SetHeader("Subject", subject.String())
+	for key, value := range m.headers {
+		msg.SetHeader(key, value)
+	}
 	msg.SetBody("text/plain", plainBody.String())
 	msg.AddAlternative("text/html", htmlBody.String())
 
@@ -0,0 +1,304 @@
+// Package metrics is a small metrics registry which is served in the Prometheus text
+// format. A Registry holds its own metrics rather than using global state like expvar,
+// so creating one twice doesn't panic.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds of histogram buckets for request latencies, in
+// seconds. They're the same as the Prometheus client's.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+func New() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// register adds a metric to the registry. Two metrics with the same name would make
+// the output invalid, so that's treated as a programming error.
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names[name] {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// Handler serves the registry's metrics in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		bw := bufio.NewWriter(w)
+
+		r.mu.Lock()
+		metrics := slices.Clone(r.metrics)
+		r.mu.Unlock()
+
+		for _, m := range metrics {
+			m.write(bw)
+		}
+
+		bw.Flush()
+	})
+}
+
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.kind)
+}
+
+// key turns label values into a map key, checking there are the right number of them.
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// CounterVec is a counter for each combination of label values.
+type CounterVec struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	value  float64
+}
+
+// NewCounterVec registers a counter with the given labels. Use it without labels for a
+// plain counter.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		desc:   desc{name: name, help: help, kind: "counter", labels: labels},
+		series: make(map[string]*counterSeries),
+	}
+	r.register(name, c)
+	return c
+}
+
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *CounterVec) Add(v float64, values ...string) {
+	key := c.key(values)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, found := c.series[key]
+	if !found {
+		s = &counterSeries{values: slices.Clone(values)}
+		c.series[key] = s
+	}
+	s.value += v
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeHeader(w)
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelPairs(c.labels, s.values, "", ""), formatFloat(s.value))
+	}
+}
+
+// Gauge is a value which can go up and down.
+type Gauge struct {
+	desc
+	mu    sync.Mutex
+	value float64
+}
+
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{desc: desc{name: name, help: help, kind: "gauge"}}
+	r.register(name, g)
+	return g
+}
+
+func (g *Gauge) Add(v float64) {
+	g.mu.Lock()
+	g.value += v
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Inc() { g.Add(1) }
+func (g *Gauge) Dec() { g.Add(-1) }
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.value))
+}
+
+// funcMetric is a gauge or counter whose value is read from fn when it's scraped, for
+// values which are kept track of elsewhere, such as the database pool's.
+type funcMetric struct {
+	desc
+	fn func() float64
+}
+
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(name, &funcMetric{desc: desc{name: name, help: help, kind: "gauge"}, fn: fn})
+}
+
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(name, &funcMetric{desc: desc{name: name, help: help, kind: "counter"}, fn: fn})
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	f.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", f.name, formatFloat(f.fn()))
+}
+
+// HistogramVec counts observations into buckets for each combination of label values.
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec registers a histogram with the given bucket upper bounds, which must
+// be in increasing order. There's always a +Inf bucket as well.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		desc:    desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	r.register(name, h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	key := h.key(values)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, found := h.series[key]
+	if !found {
+		s = &histogramSeries{values: slices.Clone(values), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+
+	// Only the first bucket the value fits in is counted here; the buckets are made
+	// cumulative when they're written out.
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.writeHeader(w)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(h.labels, s.values, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(h.labels, s.values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelPairs(h.labels, s.values, "", ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelPairs(h.labels, s.values, "", ""), s.count)
+	}
+}
+
+// labelPairs formats labels as {name="value",...}, with an extra label on the end if
+// extraName isn't empty.
+func labelPairs(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+escapeLabel(extraValue)+`"`)
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sortedKeys keeps the order of the output the same from one scrape to the next.
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,195 @@
+package metrics
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, r *Registry) string {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if got := rr.Header().Get("Content-Type"); got != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("got Content-Type %q", got)
+	}
+
+	return rr.Body.String()
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(r *Registry)
+		want  string
+	}{
+		{
+			name:  "counter without observations",
+			setup: func(r *Registry) { r.NewCounterVec("requests_total", "Requests.", "method") },
+			want: `# HELP requests_total Requests.
+# TYPE requests_total counter
+`,
+		},
+		{
+			name: "counter without labels",
+			setup: func(r *Registry) {
+				c := r.NewCounterVec("jobs_total", "Jobs.")
+				c.Inc()
+				c.Add(1.5)
+			},
+			want: `# HELP jobs_total Jobs.
+# TYPE jobs_total counter
+jobs_total 2.5
+`,
+		},
+		{
+			name: "counter series are sorted",
+			setup: func(r *Registry) {
+				c := r.NewCounterVec("requests_total", "Requests.", "method", "status")
+				c.Inc("POST", "201")
+				c.Inc("GET", "200")
+				c.Inc("GET", "200")
+			},
+			want: `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{method="GET",status="200"} 2
+requests_total{method="POST",status="201"} 1
+`,
+		},
+		{
+			name: "label values and help are escaped",
+			setup: func(r *Registry) {
+				c := r.NewCounterVec("odd_total", "A \\ in\nhelp.", "value")
+				c.Inc("a\"b\\c\nd")
+			},
+			want: `# HELP odd_total A \\ in\nhelp.
+# TYPE odd_total counter
+odd_total{value="a\"b\\c\nd"} 1
+`,
+		},
+		{
+			name: "gauge",
+			setup: func(r *Registry) {
+				g := r.NewGauge("in_flight", "In flight.")
+				g.Inc()
+				g.Inc()
+				g.Dec()
+				g.Add(0.25)
+			},
+			want: `# HELP in_flight In flight.
+# TYPE in_flight gauge
+in_flight 1.25
+`,
+		},
+		{
+			name: "funcs are read at scrape time",
+			setup: func(r *Registry) {
+				r.NewGaugeFunc("open", "Open.", func() float64 { return 3 })
+				r.NewCounterFunc("waited_seconds_total", "Waited.", func() float64 { return math.Inf(1) })
+			},
+			want: `# HELP open Open.
+# TYPE open gauge
+open 3
+# HELP waited_seconds_total Waited.
+# TYPE waited_seconds_total counter
+waited_seconds_total +Inf
+`,
+		},
+		{
+			name: "histogram buckets are cumulative",
+			setup: func(r *Registry) {
+				h := r.NewHistogramVec("duration_seconds", "Duration.", []float64{0.1, 1}, "route")
+				h.Observe(0.05, "/v1/movies")
+				h.Observe(0.1, "/v1/movies")
+				h.Observe(0.5, "/v1/movies")
+				h.Observe(2, "/v1/movies")
+			},
+			want: `# HELP duration_seconds Duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{route="/v1/movies",le="0.1"} 2
+duration_seconds_bucket{route="/v1/movies",le="1"} 3
+duration_seconds_bucket{route="/v1/movies",le="+Inf"} 4
+duration_seconds_sum{route="/v1/movies"} 2.65
+duration_seconds_count{route="/v1/movies"} 4
+`,
+		},
+		{
+			name: "histogram without labels",
+			setup: func(r *Registry) {
+				h := r.NewHistogramVec("size_bytes", "Size.", []float64{100})
+				h.Observe(1000)
+			},
+			want: `# HELP size_bytes Size.
+# TYPE size_bytes histogram
+size_bytes_bucket{le="100"} 0
+size_bytes_bucket{le="+Inf"} 1
+size_bytes_sum 1000
+size_bytes_count 1
+`,
+		},
+		{
+			name: "metrics are written in the order they were registered",
+			setup: func(r *Registry) {
+				r.NewGauge("b", "B.")
+				r.NewGauge("a", "A.")
+			},
+			want: `# HELP b B.
+# TYPE b gauge
+b 0
+# HELP a A.
+# TYPE a gauge
+a 0
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New()
+			tt.setup(r)
+
+			if got := scrape(t, r); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPanics(t *testing.T) {
+	tests := []struct {
+		name  string
+		fn    func(r *Registry)
+		panic string
+	}{
+		{
+			name: "registered twice",
+			fn: func(r *Registry) {
+				r.NewGauge("a", "A.")
+				r.NewCounterVec("a", "A.")
+			},
+			panic: "metrics: a registered twice",
+		},
+		{
+			name:  "wrong number of label values",
+			fn:    func(r *Registry) { r.NewCounterVec("c", "C.", "method", "status").Inc("GET") },
+			panic: "metrics: c has 2 labels, got 1 values",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				got, _ := recover().(string)
+				if !strings.Contains(got, tt.panic) {
+					t.Errorf("got panic %q; want %q", got, tt.panic)
+				}
+			}()
+
+			tt.fn(New())
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testProvider is a stand-in identity provider serving discovery, its keys and a token
+// endpoint which checks the PKCE code verifier.
+type testProvider struct {
+	*httptest.Server
+	t      *testing.T
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+
+	// What the token endpoint expects and returns.
+	code      string
+	challenge string
+	idToken   string
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tp := &testProvider{t: t, rsaKey: rsaKey, ecKey: ecKey}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Metadata{
+			Issuer:                tp.URL,
+			AuthorizationEndpoint: tp.URL + "/authorize",
+			TokenEndpoint:         tp.URL + "/token",
+			JWKSURI:               tp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64.EncodeToString(rsaKey.N.Bytes()), "e": b64.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))), "y": b64.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32)))},
+			{"kty": "RSA", "kid": "enc", "use": "enc", "n": b64.EncodeToString(rsaKey.N.Bytes()), "e": "AQAB"},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, secret, _ := r.BasicAuth()
+		verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+
+		switch {
+		case clientID != "client" || secret != "secret":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("code") != tp.code:
+			w.WriteHeader(http.StatusBadRequest)
+		case r.PostFormValue("redirect_uri") != "https://app.example.com/callback":
+			w.WriteHeader(http.StatusBadRequest)
+		case b64.EncodeToString(verifier[:]) != tp.challenge:
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			json.NewEncoder(w).Encode(map[string]string{"id_token": tp.idToken})
+		}
+	})
+
+	tp.Server = httptest.NewServer(mux)
+	t.Cleanup(tp.Close)
+
+	return tp
+}
+
+func (tp *testProvider) relyingParty() *Provider {
+	return New(tp.URL+"/", "client", "secret", "https://app.example.com/callback")
+}
+
+// claims returns valid claims for the test provider, for tests to change.
+func (tp *testProvider) claims() map[string]any {
+	return map[string]any{
+		"iss":            tp.URL,
+		"sub":            "provider-user-1",
+		"aud":            "client",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          "nonce",
+		"email":          "alice@example.com",
+		"email_verified": true,
+	}
+}
+
+// sign returns an ID token with the given header algorithm and key ID, signed with the
+// test provider's key for signingAlg.
+func (tp *testProvider) sign(alg, kid, signingAlg string, claims map[string]any) string {
+	tp.t.Helper()
+
+	headerJSON, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	claimsJSON, _ := json.Marshal(claims)
+
+	input := b64.EncodeToString(headerJSON) + "." + b64.EncodeToString(claimsJSON)
+	hash := sha256.Sum256([]byte(input))
+
+	var signature []byte
+
+	switch signingAlg {
+	case "RS256":
+		s, err := rsa.SignPKCS1v15(rand.Reader, tp.rsaKey, crypto.SHA256, hash[:])
+		if err != nil {
+			tp.t.Fatal(err)
+		}
+		signature = s
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, tp.ecKey, hash[:])
+		if err != nil {
+			tp.t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+
+	return input + "." + b64.EncodeToString(signature)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	tp := newTestProvider(t)
+	p := tp.relyingParty()
+
+	with := func(changes map[string]any) map[string]any {
+		claims := tp.claims()
+		for k, v := range changes {
+			if v == nil {
+				delete(claims, k)
+			} else {
+				claims[k] = v
+			}
+		}
+		return claims
+	}
+
+	valid := tp.sign("RS256", "rsa", "RS256", tp.claims())
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"RS256", valid, false},
+		{"ES256", tp.sign("ES256", "ec", "ES256", tp.claims()), false},
+		{"audience array with azp", tp.sign("RS256", "rsa", "RS256", with(map[string]any{"aud": []string{"client", "other"}, "azp": "client"})), false},
+		{"issuer with a trailing slash", tp.sign("RS256", "rsa", "RS256", with(map[string]any{"iss": tp.URL + "/"})), false},
+		{"expired within the allowed skew", tp.sign("RS256", "rsa", "RS256", with(map[string]any{"exp": time.Now().Add(-30 * time.Second).Unix()})), false},
+		{"expired", tp.sign("RS256", "rsa", "RS256", with(map[string]any{"exp": time.Now().Add(-2 * time.Minute).Unix()})), true},
+		{"wrong issuer", tp.sign("RS256", "rsa", "RS256", with(map[string]any{"iss": "https://evil.example.com"})), true},
+		{"wrong audience", tp.sign("RS256", "rsa", "RS256", with(map[string]any{"aud": "other"})), true},
+		{"audience array without azp", tp.sign("RS256", "rsa", "RS256", with(map[string]any{"aud": []string{"client", "other"}})), true},
+		{"wrong nonce", tp.sign("RS256", "rsa", "RS256", with(map[string]any{"nonce": "replayed"})), true},
+		{"no subject", tp.sign("RS256", "rsa", "RS256", with(map[string]any{"sub": nil})), true},
+		{"unknown key ID", tp.sign("RS256", "missing", "RS256", tp.claims()), true},
+		{"encryption key", tp.sign("RS256", "enc", "RS256", tp.claims()), true},
+		{"alg none", tp.sign("none", "rsa", "", tp.claims()), true},
+		{"ES256 header on an RSA key", tp.sign("ES256", "rsa", "ES256", tp.claims()), true},
+		{"RS256 header on an EC key", tp.sign("RS256", "ec", "RS256", tp.claims()), true},
+		{"HS256", tp.sign("HS256", "rsa", "RS256", tp.claims()), true},
+		{"tampered signature", valid[:len(valid)-10] + "AAAAAAAAAA", true},
+		{"two segments", "a.b", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := p.VerifyIDToken(context.Background(), tt.token, "nonce")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v; want error: %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && (claims.Subject != "provider-user-1" || claims.Email != "alice@example.com" || !bool(claims.EmailVerified)) {
+				t.Errorf("got claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestEmailVerified(t *testing.T) {
+	tp := newTestProvider(t)
+	p := tp.relyingParty()
+
+	tests := []struct {
+		value any
+		want  bool
+	}{
+		{true, true},
+		{"true", true},
+		{false, false},
+		{"false", false},
+		{nil, false},
+	}
+
+	for _, tt := range tests {
+		claims := tp.claims()
+		claims["email_verified"] = tt.value
+
+		got, err := p.VerifyIDToken(context.Background(), tp.sign("RS256", "rsa", "RS256", claims), "nonce")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bool(got.EmailVerified) != tt.want {
+			t.Errorf("email_verified %v: got %t; want %t", tt.value, got.EmailVerified, tt.want)
+		}
+	}
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	tp := newTestProvider(t)
+	p := tp.relyingParty()
+	ctx := context.Background()
+
+	authURL, err := p.AuthCodeURL(ctx, "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := u.Query()
+	for param, want := range map[string]string{
+		"response_type":         "code",
+		"client_id":             "client",
+		"redirect_uri":          "https://app.example.com/callback",
+		"state":                 "state",
+		"nonce":                 "nonce",
+		"code_challenge_method": "S256",
+	} {
+		if got := query.Get(param); got != want {
+			t.Errorf("got %s %q; want %q", param, got, want)
+		}
+	}
+	if u.Scheme+"://"+u.Host+u.Path != tp.URL+"/authorize" {
+		t.Errorf("got authorization URL %q", authURL)
+	}
+
+	tp.code = "code"
+	tp.challenge = query.Get("code_challenge")
+	tp.idToken = tp.sign("RS256", "rsa", "RS256", tp.claims())
+
+	tests := []struct {
+		name     string
+		code     string
+		verifier string
+		wantErr  bool
+	}{
+		{"matching verifier", "code", "verifier", false},
+		{"wrong verifier", "code", "other", true},
+		{"wrong code", "other", "verifier", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idToken, err := p.Exchange(ctx, tt.code, tt.verifier)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v; want error: %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, ErrExchangeFailed) {
+					t.Errorf("got error %v; want %v", err, ErrExchangeFailed)
+				}
+				return
+			}
+			if idToken != tp.idToken {
+				t.Errorf("got ID token %q; want %q", idToken, tp.idToken)
+			}
+		})
+	}
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Metadata{Issuer: "https://evil.example.com"})
+	}))
+	defer srv.Close()
+
+	p := New(srv.URL, "client", "secret", "https://app.example.com/callback")
+
+	_, err := p.Discover(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "expected") {
+		t.Fatalf("got error %v; want an issuer mismatch", err)
+	}
+}
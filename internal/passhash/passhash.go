@@ -0,0 +1,204 @@
+// Password hashing. Every hash is stored in a self-describing format which records the
+// algorithm and its parameters (PHC string format for argon2id, the usual modular crypt
+// format for bcrypt), so hashes made with old algorithms or parameters can still be
+// verified after the configuration changes, and can be spotted and upgraded.
+
+package passhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidHash      = errors.New("passhash: invalid hash")
+	ErrUnknownAlgorithm = errors.New("passhash: unknown hash algorithm")
+	ErrPasswordTooLong  = errors.New("passhash: password too long")
+)
+
+// Hasher makes new password hashes.
+type Hasher interface {
+	// Hash returns an encoded hash of the password.
+	Hash(plaintext string) ([]byte, error)
+	// NeedsRehash reports whether an encoded hash was made with a different algorithm
+	// or different parameters, and should be replaced on the next successful login.
+	NeedsRehash(encoded []byte) bool
+	// MaxLength is the longest password, in bytes, the hasher accepts.
+	MaxLength() int
+}
+
+// New returns the hasher for the named algorithm with its default parameters.
+func New(algorithm string) (Hasher, error) {
+	switch algorithm {
+	case "argon2id":
+		return DefaultArgon2id, nil
+	case "bcrypt":
+		return DefaultBcrypt, nil
+	default:
+		return nil, ErrUnknownAlgorithm
+	}
+}
+
+// Verify checks a password against an encoded hash made by any of the hashers in this
+// package, using the algorithm and parameters recorded in the hash.
+func Verify(plaintext string, encoded []byte) (bool, error) {
+	switch {
+	case strings.HasPrefix(string(encoded), "$argon2id$"):
+		return verifyArgon2id(plaintext, encoded)
+	case strings.HasPrefix(string(encoded), "$2"):
+		return verifyBcrypt(plaintext, encoded)
+	default:
+		return false, ErrUnknownAlgorithm
+	}
+}
+
+// Argon2id hashes passwords with argon2id (RFC 9106).
+type Argon2id struct {
+	Memory      uint32 // in KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  int
+	KeyLength   uint32
+}
+
+// DefaultArgon2id uses the second recommended option from RFC 9106 section 4, with
+// less parallelism to suit a web server handling many logins at once.
+var DefaultArgon2id = Argon2id{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+var b64 = base64.RawStdEncoding
+
+func (h Argon2id) Hash(plaintext string) ([]byte, error) {
+	if len(plaintext) > h.MaxLength() {
+		return nil, ErrPasswordTooLong
+	}
+
+	salt := make([]byte, h.SaltLength)
+
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(plaintext), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism, b64.EncodeToString(salt), b64.EncodeToString(key))
+
+	return []byte(encoded), nil
+}
+
+func (h Argon2id) NeedsRehash(encoded []byte) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+
+	return params.Memory != h.Memory ||
+		params.Iterations != h.Iterations ||
+		params.Parallelism != h.Parallelism ||
+		len(salt) != h.SaltLength ||
+		uint32(len(key)) != h.KeyLength
+}
+
+// MaxLength for argon2id is only there to stop absurdly large inputs; unlike bcrypt it
+// uses every byte of the password.
+func (h Argon2id) MaxLength() int {
+	return 1024
+}
+
+func verifyArgon2id(plaintext string, encoded []byte) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	otherKey := argon2.IDKey([]byte(plaintext), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, otherKey) == 1, nil
+}
+
+func decodeArgon2id(encoded []byte) (params Argon2id, salt, key []byte, err error) {
+	parts := strings.Split(string(encoded), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	salt, err = b64.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	key, err = b64.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	return params, salt, key, nil
+}
+
+// Bcrypt hashes passwords with bcrypt. It's kept so that existing hashes still verify,
+// and can be selected for new ones, but bcrypt ignores everything after 72 bytes.
+type Bcrypt struct {
+	Cost int
+}
+
+var DefaultBcrypt = Bcrypt{Cost: 12}
+
+func (h Bcrypt) Hash(plaintext string) ([]byte, error) {
+	if len(plaintext) > h.MaxLength() {
+		return nil, ErrPasswordTooLong
+	}
+
+	return bcrypt.GenerateFromPassword([]byte(plaintext), h.Cost)
+}
+
+func (h Bcrypt) NeedsRehash(encoded []byte) bool {
+	cost, err := bcrypt.Cost(encoded)
+	return err != nil || cost != h.Cost
+}
+
+func (h Bcrypt) MaxLength() int {
+	return 72
+}
+
+func verifyBcrypt(plaintext string, encoded []byte) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(encoded, []byte(plaintext))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		// Longer passwords can't have been used to make a bcrypt hash.
+		case errors.Is(err, bcrypt.ErrPasswordTooLong):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
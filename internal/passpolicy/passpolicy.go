@@ -0,0 +1,326 @@
+// Password policy for new passwords: a minimum length, a minimum strength as estimated
+// by a simple entropy and pattern scorer, no personal information, and no passwords
+// known to have appeared in a data breach.
+
+package passpolicy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+type Policy struct {
+	MinLength int
+	// MinScore is the lowest acceptable Score, from 0 (trivially guessable) to 4.
+	MinScore int
+	// Breached is the corpus of breached passwords to reject, or nil to skip the check.
+	Breached *Corpus
+}
+
+// Check returns the reasons, if any, why password isn't acceptable. The personal
+// strings (the user's name and email address) must not appear in the password.
+func (p Policy) Check(password string, personal ...string) []string {
+	var problems []string
+
+	if len(password) < p.MinLength {
+		problems = append(problems, fmt.Sprintf("must be at least %d bytes long", p.MinLength))
+	}
+
+	if part := containsPersonal(password, personal); part != "" {
+		problems = append(problems, fmt.Sprintf("must not contain your %s", part))
+	}
+
+	if score, feedback := Score(password); score < p.MinScore {
+		problems = append(problems, "is too easy to guess: "+feedback)
+	}
+
+	if p.Breached != nil && p.Breached.Contains(password) {
+		problems = append(problems, "has appeared in a data breach and must not be used")
+	}
+
+	return problems
+}
+
+// containsPersonal returns which personal detail the password contains, if any. Names
+// are checked word by word, and email addresses by their local part too, ignoring
+// pieces too short to matter.
+func containsPersonal(password string, personal []string) string {
+	lower := strings.ToLower(password)
+
+	for _, value := range personal {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value == "" {
+			continue
+		}
+
+		if local, _, ok := strings.Cut(value, "@"); ok {
+			if strings.Contains(lower, value) || (len(local) >= 3 && strings.Contains(lower, local)) {
+				return "email address"
+			}
+			continue
+		}
+
+		for _, word := range strings.Fields(value) {
+			if len(word) >= 3 && strings.Contains(lower, word) {
+				return "name"
+			}
+		}
+	}
+
+	return ""
+}
+
+// Score estimates how hard a password is to guess, from 0 to 4, along with advice
+// about its weakest part. It starts from the entropy of a random string with the same
+// length and character classes, then discounts characters which belong to common
+// passwords, repeats, sequences and years, since those are what guessing tools try first.
+func Score(password string) (int, string) {
+	chars := []rune(strings.ToLower(password))
+	if len(chars) == 0 {
+		return 0, "add more characters"
+	}
+
+	perChar := math.Log2(float64(charsetSize(password)))
+
+	bits := make([]float64, len(chars))
+	for i := range bits {
+		bits[i] = perChar
+	}
+
+	feedback := "add more characters, or use a few uncommon words"
+
+	// Common passwords and words, including with l33t substitutions, cost about as
+	// much as picking one from the list.
+	normalised := unleet(chars)
+	for _, word := range commonWords {
+		needle := []rune(word)
+
+		for start := indexRunes(normalised, needle, 0); start >= 0; start = indexRunes(normalised, needle, start+len(needle)) {
+			discount(bits, start, start+len(needle), math.Log2(float64(len(commonWords))))
+			feedback = "avoid common words and passwords"
+		}
+	}
+
+	for i := 1; i < len(chars); i++ {
+		switch {
+		// Repeats: "aaaa".
+		case chars[i] == chars[i-1]:
+			bits[i] = math.Min(bits[i], 1)
+			if feedback == "add more characters, or use a few uncommon words" {
+				feedback = "avoid repeated characters"
+			}
+		// Sequences: "abcd", "4321", "qwerty".
+		case i >= 2 && inSequence(chars[i-2], chars[i-1], chars[i]):
+			bits[i-1] = math.Min(bits[i-1], 1)
+			bits[i] = math.Min(bits[i], 1)
+			if feedback == "add more characters, or use a few uncommon words" {
+				feedback = "avoid sequences like abc, 123 or qwerty"
+			}
+		}
+	}
+
+	// Recent years, which often end a password, are one of about 200 likely values.
+	for i := 0; i+4 <= len(chars); i++ {
+		if isYear(chars[i : i+4]) {
+			discount(bits, i, i+4, math.Log2(200))
+		}
+	}
+
+	var total float64
+	for _, b := range bits {
+		total += b
+	}
+
+	switch {
+	case total < 20:
+		return 0, feedback
+	case total < 30:
+		return 1, feedback
+	case total < 40:
+		return 2, feedback
+	case total < 60:
+		return 3, feedback
+	default:
+		return 4, feedback
+	}
+}
+
+// discount lowers the bits for chars[start:end] so that together they are worth at
+// most the given number of bits.
+func discount(bits []float64, start, end int, worth float64) {
+	var current float64
+	for _, b := range bits[start:end] {
+		current += b
+	}
+	if current <= worth {
+		return
+	}
+
+	for i := start; i < end; i++ {
+		bits[i] = worth / float64(end-start)
+	}
+}
+
+// indexRunes returns the index of the first needle in haystack at or after from, or -1.
+func indexRunes(haystack, needle []rune, from int) int {
+	for i := from; i+len(needle) <= len(haystack); i++ {
+		if slices.Equal(haystack[i:i+len(needle)], needle) {
+			return i
+		}
+	}
+	return -1
+}
+
+func charsetSize(password string) int {
+	var lower, upper, digit, symbol, other bool
+
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	size := 0
+	for _, class := range []struct {
+		present bool
+		size    int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.present {
+			size += class.size
+		}
+	}
+
+	return size
+}
+
+var sequences = []string{
+	"abcdefghijklmnopqrstuvwxyz",
+	"01234567890",
+	"qwertyuiop",
+	"asdfghjkl",
+	"zxcvbnm",
+}
+
+// inSequence reports whether a, b and c are consecutive, forwards or backwards, in the
+// alphabet, the digits or a keyboard row.
+func inSequence(a, b, c rune) bool {
+	s := string([]rune{a, b, c})
+	r := string([]rune{c, b, a})
+
+	for _, seq := range sequences {
+		if strings.Contains(seq, s) || strings.Contains(seq, r) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isYear(chars []rune) bool {
+	s := string(chars)
+	return (strings.HasPrefix(s, "19") || strings.HasPrefix(s, "20")) &&
+		unicode.IsDigit(chars[2]) && unicode.IsDigit(chars[3])
+}
+
+var leet = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '@': 'a', '$': 's', '!': 'i',
+}
+
+func unleet(chars []rune) []rune {
+	normalised := make([]rune, len(chars))
+	for i, r := range chars {
+		if n, ok := leet[r]; ok {
+			normalised[i] = n
+		} else {
+			normalised[i] = r
+		}
+	}
+	return normalised
+}
+
+// Corpus is a set of breached passwords, held as the first 64 bits of their SHA-1
+// hashes. That is plenty to make false positives vanishingly rare, at a fraction of
+// the memory the full hashes would need.
+type Corpus struct {
+	prefixes []uint64
+}
+
+// LoadCorpusFile loads a corpus from a file of hex-encoded SHA-1 hashes, one per line.
+// Each line needs at least the first 16 hex digits of a hash, so truncated hashes can
+// be used to save space; anything after a colon (such as the counts in the Have I Been
+// Pwned downloads) is ignored, as are blank lines and lines starting with #.
+func LoadCorpusFile(path string) (*Corpus, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return LoadCorpus(file)
+}
+
+func LoadCorpus(r io.Reader) (*Corpus, error) {
+	var prefixes []uint64
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		text, _, _ = strings.Cut(text, ":")
+		if len(text) < 16 {
+			return nil, fmt.Errorf("passpolicy: line %d: hash prefix must be at least 16 hex digits", line)
+		}
+
+		prefix, err := hex.DecodeString(text[:16])
+		if err != nil {
+			return nil, fmt.Errorf("passpolicy: line %d: %w", line, err)
+		}
+
+		prefixes = append(prefixes, binary.BigEndian.Uint64(prefix))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.Sort(prefixes)
+
+	return &Corpus{prefixes: slices.Compact(prefixes)}, nil
+}
+
+// Len returns the number of distinct passwords in the corpus.
+func (c *Corpus) Len() int {
+	return len(c.prefixes)
+}
+
+func (c *Corpus) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+
+	_, found := slices.BinarySearch(c.prefixes, binary.BigEndian.Uint64(sum[:8]))
+	return found
+}
@@ -0,0 +1,18 @@
+package passpolicy
+
+// commonWords are the words and passwords that turn up most often in breached password
+// lists, lower-cased and without l33t substitutions. The breached password corpus
+// catches far more, but only if one is configured.
+var commonWords = []string{
+	"password", "passw", "qwerty", "letmein", "welcome", "admin", "login", "master",
+	"dragon", "monkey", "football", "baseball", "soccer", "hockey", "basketball",
+	"iloveyou", "love", "princess", "sunshine", "shadow", "superman", "batman",
+	"trustno", "whatever", "freedom", "starwars", "pokemon", "computer", "internet",
+	"secret", "hello", "charlie", "michael", "jordan", "jennifer", "thomas", "daniel",
+	"ashley", "jessica", "hunter", "ranger", "buster", "tigger", "pepper", "ginger",
+	"summer", "winter", "spring", "autumn", "monday", "friday", "january", "december",
+	"cheese", "chocolate", "cookie", "banana", "orange", "purple", "silver", "golden",
+	"flower", "matrix", "killer", "hacker", "access", "changeme", "default", "guest",
+	"test", "user", "root", "abc", "qazwsx", "zaq", "asdf", "zxcv", "greenlight",
+	"movie", "movies", "cinema", "film",
+}
@@ -0,0 +1,109 @@
+// Time-Based One-Time Passwords (RFC 6238) for two-factor authentication, using the
+// parameters every authenticator app supports: HMAC-SHA1, 6 digits, 30 second steps.
+
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	Digits = 6
+	Period = 30
+)
+
+// modulus keeps the last Digits digits of a code.
+var modulus = uint32(math.Pow10(Digits))
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random 160 bit secret, base32-encoded as authenticator
+// apps expect.
+func GenerateSecret() (string, error) {
+	secret := make([]byte, 20)
+
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(secret), nil
+}
+
+// URI returns the otpauth:// provisioning URI for a secret, which is what gets encoded
+// in the QR code that users scan with their authenticator app.
+func URI(issuer, account, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(Period))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Step returns the time step t falls in.
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// Code returns the code for a secret at the given time step (RFC 4226 section 5.3).
+func Code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", Digits, value%modulus), nil
+}
+
+// Validate checks a code against the steps either side of t, to allow for clock drift
+// and slow typing. Only steps after lastStep are accepted, so that a code can't be
+// replayed once it has been used. It returns the step the code matched.
+func Validate(secret, code string, t time.Time, lastStep int64) (int64, bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	current := Step(t)
+
+	for step := current - 1; step <= current+1; step++ {
+		if step <= lastStep {
+			continue
+		}
+
+		expected, err := Code(secret, step)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,95 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// The secret from the test vectors in RFC 6238 appendix B, "12345678901234567890",
+// base32-encoded. The RFC's codes are 8 digits long; ours are their last 6.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d: got %q; want %q", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCodeLowercaseSecret(t *testing.T) {
+	upper, err := Code(rfcSecret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lower, err := Code("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if upper != lower {
+		t.Errorf("got %q for the lowercase secret; want %q", lower, upper)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	code := func(step int64) string {
+		c, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		lastStep int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", rfcSecret, code(current), 0, current, true},
+		{"previous step", rfcSecret, code(current - 1), 0, current - 1, true},
+		{"next step", rfcSecret, code(current + 1), 0, current + 1, true},
+		{"two steps behind", rfcSecret, code(current - 2), 0, 0, false},
+		{"two steps ahead", rfcSecret, code(current + 2), 0, 0, false},
+		{"already used", rfcSecret, code(current), current, 0, false},
+		{"earlier step after a later one was used", rfcSecret, code(current - 1), current, 0, false},
+		{"later step after an earlier one was used", rfcSecret, code(current + 1), current, current + 1, true},
+		{"wrong code", rfcSecret, "000000", 0, 0, false},
+		{"too short", rfcSecret, code(current)[:5], 0, 0, false},
+		{"too long", rfcSecret, code(current) + "0", 0, 0, false},
+		{"empty", rfcSecret, "", 0, 0, false},
+		{"invalid secret", "not base32!", "123456", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(tt.secret, tt.code, now, tt.lastStep)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("got (%d, %t); want (%d, %t)", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE code = 'tokens:revoke';
+DROP TABLE IF EXISTS revoked_tokens;
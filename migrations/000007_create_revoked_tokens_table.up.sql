@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    id bigserial PRIMARY KEY,
+    jti text UNIQUE,
+    user_id bigint REFERENCES users ON DELETE CASCADE,
+    revoked_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expiry timestamp(0) with time zone NOT NULL,
+    CHECK (jti IS NOT NULL OR user_id IS NOT NULL)
+);
+
+INSERT INTO permissions (code)
+VALUES
+    ('tokens:revoke');
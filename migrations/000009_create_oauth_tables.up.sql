@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS oauth_clients (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    client_id text UNIQUE NOT NULL,
+    secret_hash bytea,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    name text NOT NULL,
+    redirect_uris text[] NOT NULL DEFAULT '{}',
+    scopes text[] NOT NULL,
+    grant_types text[] NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS oauth_authorization_codes (
+    hash bytea PRIMARY KEY,
+    client_id bigint NOT NULL REFERENCES oauth_clients ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    redirect_uri text NOT NULL,
+    scopes text[] NOT NULL,
+    code_challenge text NOT NULL,
+    expiry timestamp(0) with time zone NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS oauth_consents (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    client_id bigint NOT NULL REFERENCES oauth_clients ON DELETE CASCADE,
+    scopes text[] NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, client_id)
+);
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS client_id bigint REFERENCES oauth_clients ON DELETE CASCADE;
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS permissions text[];
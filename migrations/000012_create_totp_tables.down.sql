@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'users:admin';
+DROP TABLE IF EXISTS totp_recovery_codes;
+DROP TABLE IF EXISTS users_totp;
@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'permissions:admin';
+
+DROP TABLE IF EXISTS permission_changes;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS permission_changes (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    actor_id bigint REFERENCES users ON DELETE SET NULL,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    action text NOT NULL CHECK (action IN ('grant', 'revoke')),
+    codes text[] NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS permission_changes_user_id_idx ON permission_changes (user_id);
+
+INSERT INTO permissions (code)
+VALUES
+    ('permissions:admin');
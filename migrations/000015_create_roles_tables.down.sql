@@ -0,0 +1,12 @@
+DELETE FROM permission_changes WHERE action IN ('assign_role', 'unassign_role');
+
+ALTER TABLE permission_changes DROP CONSTRAINT IF EXISTS permission_changes_action_check;
+ALTER TABLE permission_changes ADD CONSTRAINT permission_changes_action_check
+    CHECK (action IN ('grant', 'revoke'));
+
+DELETE FROM permissions WHERE code = 'movies:*';
+
+DROP TABLE IF EXISTS users_roles;
+DROP TABLE IF EXISTS roles_parents;
+DROP TABLE IF EXISTS roles_permissions;
+DROP TABLE IF EXISTS roles;
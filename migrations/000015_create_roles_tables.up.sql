@@ -0,0 +1,52 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name citext UNIQUE NOT NULL,
+    description text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions (
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+-- A role has the permissions of its parents, and of their parents in turn.
+CREATE TABLE IF NOT EXISTS roles_parents (
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    parent_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    PRIMARY KEY (role_id, parent_id),
+    CHECK (role_id <> parent_id)
+);
+
+CREATE TABLE IF NOT EXISTS users_roles (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+
+ALTER TABLE permission_changes DROP CONSTRAINT IF EXISTS permission_changes_action_check;
+ALTER TABLE permission_changes ADD CONSTRAINT permission_changes_action_check
+    CHECK (action IN ('grant', 'revoke', 'assign_role', 'unassign_role'));
+
+INSERT INTO permissions (code)
+VALUES
+    ('movies:*');
+
+INSERT INTO roles (name, description)
+VALUES
+    ('viewer', 'Can browse movies'),
+    ('editor', 'Can add and edit movies'),
+    ('admin', 'Can manage users and their permissions');
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id FROM roles, permissions
+WHERE (roles.name = 'viewer' AND permissions.code = 'movies:read')
+OR (roles.name = 'editor' AND permissions.code = 'movies:write')
+OR (roles.name = 'admin' AND permissions.code IN ('permissions:admin', 'users:admin', 'tokens:revoke', 'tokens:introspect'));
+
+INSERT INTO roles_parents
+SELECT roles.id, parents.id FROM roles, roles AS parents
+WHERE (roles.name = 'editor' AND parents.name = 'viewer')
+OR (roles.name = 'admin' AND parents.name = 'editor');
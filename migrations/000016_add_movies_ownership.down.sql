@@ -0,0 +1,6 @@
+DELETE FROM permissions WHERE code = 'movies:admin';
+
+DROP TABLE IF EXISTS movies_collaborators;
+
+DROP INDEX IF EXISTS movies_created_by_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS created_by;
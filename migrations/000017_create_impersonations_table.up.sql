@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS impersonations (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    actor_id bigint REFERENCES users ON DELETE SET NULL,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    reason text NOT NULL,
+    expiry timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS impersonations_user_id_idx ON impersonations (user_id);
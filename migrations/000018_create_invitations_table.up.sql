@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS invitations (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    hash bytea UNIQUE NOT NULL,
+    email citext NOT NULL,
+    permissions text[] NOT NULL,
+    created_by bigint REFERENCES users ON DELETE SET NULL,
+    expiry timestamp(0) with time zone NOT NULL,
+    accepted_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS invitations_email_idx ON invitations (email);
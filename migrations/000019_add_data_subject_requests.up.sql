@@ -0,0 +1,12 @@
+-- Set when a user asks for their account to be deleted. The account is deleted (or
+-- anonymised) once the grace period has passed, unless they log in before then.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_requested_at timestamp(0) with time zone;
+
+CREATE TABLE IF NOT EXISTS data_exports (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    hash bytea UNIQUE NOT NULL,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    archive bytea NOT NULL,
+    expiry timestamp(0) with time zone NOT NULL
+);
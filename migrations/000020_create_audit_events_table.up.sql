@@ -0,0 +1,28 @@
+-- There are no foreign keys on the user IDs, so that events outlive the users in them.
+CREATE TABLE IF NOT EXISTS audit_events (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    action text NOT NULL,
+    actor_id bigint,
+    subject_id bigint,
+    ip text NOT NULL DEFAULT '',
+    user_agent text NOT NULL DEFAULT '',
+    request_id text NOT NULL DEFAULT '',
+    details jsonb NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_created_at_idx ON audit_events (created_at);
+CREATE INDEX IF NOT EXISTS audit_events_actor_id_idx ON audit_events (actor_id);
+CREATE INDEX IF NOT EXISTS audit_events_subject_id_idx ON audit_events (subject_id);
+
+-- Events are only ever inserted, and deleted once they're past the retention period.
+CREATE OR REPLACE RULE audit_events_no_update AS ON UPDATE TO audit_events DO INSTEAD NOTHING;
+
+INSERT INTO permissions (code)
+VALUES
+    ('audit:read');
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code = 'audit:read'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,17 @@
+-- The IP addresses and user agents each user has logged in from, so that logins from
+-- somewhere new can be reported to them.
+CREATE TABLE IF NOT EXISTS user_logins (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    ip text NOT NULL,
+    user_agent text NOT NULL,
+    first_seen_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_seen_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, ip, user_agent)
+);
+
+-- The security notifications each user has turned off.
+CREATE TABLE IF NOT EXISTS notification_opt_outs (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    kind text NOT NULL,
+    PRIMARY KEY (user_id, kind)
+);
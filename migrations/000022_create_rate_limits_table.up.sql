@@ -0,0 +1,7 @@
+-- Token buckets shared between API instances, kept with the generic cell rate
+-- algorithm: tat is the bucket's theoretical arrival time, in seconds since the epoch,
+-- when it will be full again.
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limits (
+    key text PRIMARY KEY,
+    tat double precision NOT NULL
+);
@@ -0,0 +1,3 @@
+-- Tokens given to support staff to act as a user point at the impersonation they
+-- were issued for, so that they can be told apart from the user's own tokens.
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS impersonation_id bigint REFERENCES impersonations ON DELETE CASCADE;
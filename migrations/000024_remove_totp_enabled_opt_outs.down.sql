@@ -0,0 +1 @@
+-- The opt-outs removed by the up migration can't be brought back.
@@ -0,0 +1,2 @@
+-- totp_enabled notifications can't be turned off any more.
+DELETE FROM notification_opt_outs WHERE kind = 'totp_enabled';
@@ -0,0 +1,3 @@
+DELETE FROM revoked_tokens WHERE user_id IS NOT NULL AND user_id NOT IN (SELECT id FROM users);
+
+ALTER TABLE revoked_tokens ADD CONSTRAINT revoked_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES users ON DELETE CASCADE;
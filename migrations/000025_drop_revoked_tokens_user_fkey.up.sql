@@ -0,0 +1,3 @@
+-- Revocations have to outlive the user, or a deleted user's JWTs would be accepted
+-- again until they expired. They're pruned by expiry instead.
+ALTER TABLE revoked_tokens DROP CONSTRAINT IF EXISTS revoked_tokens_user_id_fkey;